@@ -3,6 +3,7 @@ package basicserver
 import (
 	"errors"
 
+	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	"github.com/kataras/iris"
 )
@@ -34,27 +35,85 @@ func (app *BasicApp) ServeRemoveAccountDelete() iris.Handler {
 		objectUID := bson.ObjectIdHex(uid)
 
 		// remove all user files
-		filenamePrefix := "^" + uid + ":"
-		var item fileItem
-		iter := app.Coll.Files.Find(bson.M{"filename": bson.RegEx{Pattern: filenamePrefix}}).Iter()
-		for iter.Next(&item) {
-			err := app.Coll.Files.RemoveId(item.ID)
+		err := app.removeStoredFiles(bson.M{"filename": userFilesRange(uid)})
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		// remove cached variants of user images
+		err = app.removeStoredFiles(bson.M{"filename": bson.M{"$gt": "thumb:" + uid + ":", "$lt": "thumb:" + uid + ";"}})
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		// remove resumable uploads of user files
+		var upload Upload
+		iter := app.Coll.Uploads.Find(bson.M{"uid": objectUID}).Select(bson.M{"_id": 1}).Iter()
+		for iter.Next(&upload) {
+			err := app.removeUpload(upload.ID)
 			if err != nil {
 				app.HandleError(err, ctx, iris.StatusInternalServerError)
 				return
 			}
 		}
 
-		// then remove user state
-		err := app.Coll.States.RemoveId(objectUID)
+		// remove all links to user files
+		_, err = app.Coll.Links.RemoveAll(bson.M{"uid": objectUID})
 		if err != nil {
-			if err.Error() != "not found" { // state might not be existing yet
-				app.HandleError(err, ctx, iris.StatusInternalServerError)
-			}
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		// remove user records of all the collections
+		_, err = app.Coll.Records.RemoveAll(bson.M{"uid": objectUID})
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		// remove user storage usage
+		err = app.Coll.Usage.RemoveId(objectUID)
+		if err != nil && err.Error() != "not found" {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		// remove history of user states in all the namespaces
+		var states []State
+		err = app.Coll.States.Find(userStatesSelector(objectUID)).Select(bson.M{"_id": 1}).All(&states)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+		stateIDs := []bson.ObjectId{objectUID}
+		for _, state := range states {
+			stateIDs = append(stateIDs, state.ID)
+		}
+		_, err = app.Coll.History.RemoveAll(bson.M{"uid": bson.M{"$in": stateIDs}})
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		// then remove user states
+		_, err = app.Coll.States.RemoveAll(userStatesSelector(objectUID))
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		// along with the user data key, so any left copies of encrypted data can't be read
+		err = app.Coll.DataKeys.RemoveId(objectUID)
+		if err != nil && err.Error() != "not found" {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
 		}
 
 		// to finally remove the user
-		err = app.Coll.Users.RemoveId(objectUID)
+		var user User
+		_, err = app.Coll.Users.FindId(objectUID).Apply(mgo.Change{Remove: true}, &user)
 		if err != nil {
 			if err.Error() == "not found" {
 				err := errors.New("Account Not Found")
@@ -64,5 +123,7 @@ func (app *BasicApp) ServeRemoveAccountDelete() iris.Handler {
 			app.HandleError(err, ctx, iris.StatusInternalServerError)
 			return
 		}
+
+		app.publishAccountEvent(objectUID, "account_delete", user.Email)
 	}
 }
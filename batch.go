@@ -0,0 +1,295 @@
+package basicserver
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+const (
+	batchOrphanAge     = time.Hour
+	batchSweepInterval = time.Hour
+)
+
+var (
+	errUnsupportedBatch = errors.New("Unsupported Batch Operation")
+	errNoSuchFile       = errors.New("No Such File")
+	errFileExists       = errors.New("File Exists")
+)
+
+// batchOperation is a single operation of POST /api/batch request:
+//
+//    `Op` "set", "unset", "patch", "file_delete" or "file_rename"
+//    `Key` dotted path of the user data to set or unset
+//    `Value` value to set
+//    `Patch` RFC 6902 JSON Patch operations to apply to the user data
+//    `Name` name of the file to delete or rename
+//    `To` new name of the renamed file
+//
+type batchOperation struct {
+	Op    string               `json:"op"`
+	Key   string               `json:"key,omitempty"`
+	Value interface{}          `json:"value,omitempty"`
+	Patch []jsonPatchOperation `json:"patch,omitempty"`
+	Name  string               `json:"name,omitempty"`
+	To    string               `json:"to,omitempty"`
+}
+
+func (operation *batchOperation) isFileOperation() bool {
+	return operation.Op == "file_delete" || operation.Op == "file_rename"
+}
+
+// batchResult is the outcome of a single batch operation. Status is "ok" once the batch is
+// applied. If any operation fails, it's status is "failed" and the others are "aborted".
+type batchResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchError is an error of the batch operation at given index.
+type batchError struct {
+	index int
+	err   error
+}
+
+func (err *batchError) Error() string {
+	return err.err.Error()
+}
+
+// applyBatchData applies the data operations of the batch to the user data in order.
+// It returns the data after the operations along with the matching Mongo update
+// operations, or `*batchError` of the failed operation.
+func applyBatchData(data map[string]interface{}, operations []batchOperation) (map[string]interface{}, *dataUpdate, error) {
+	update := &dataUpdate{}
+	var doc interface{} = data
+	for i, operation := range operations {
+		var err error
+		switch operation.Op {
+		case "set", "unset":
+			var path []string
+			path, err = parseDataPath(operation.Key)
+			if err != nil {
+				break
+			}
+			if operation.Op == "unset" {
+				doc = unsetPathValue(doc, path)
+				update.add("$unset", path, "")
+				break
+			}
+			doc, err = setJSONPath(doc, path, operation.Value)
+			update.add("$set", path, nil)
+		case "patch":
+			var patched map[string]interface{}
+			var patchUpdate *dataUpdate
+			patched, patchUpdate, err = applyJSONPatch(doc.(map[string]interface{}), operation.Patch)
+			if err == nil {
+				doc = patched
+				update.ops = append(update.ops, patchUpdate.ops...)
+			}
+		case "file_delete", "file_rename":
+		default:
+			err = errUnsupportedBatch
+		}
+		if err != nil {
+			return nil, nil, &batchError{index: i, err: err}
+		}
+	}
+	return doc.(map[string]interface{}), update, nil
+}
+
+// setJSONPath sets value under the path of JSON document, creating missing objects the
+// same way as Mongo `$set` does. Paths through the other values fail the same way as well.
+func setJSONPath(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	switch n := node.(type) {
+	case nil:
+		return setJSONPath(map[string]interface{}{}, path, value)
+	case map[string]interface{}:
+		child, err := setJSONPath(n[path[0]], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = child
+		return n, nil
+	case []interface{}:
+		i, err := arrayIndex(n, path[0], false)
+		if err != nil {
+			return nil, errUnsupportedUpdate
+		}
+		n[i], err = setJSONPath(n[i], path[1:], value)
+		return n, err
+	}
+	return nil, errUnsupportedUpdate
+}
+
+// fileStep is an executed file operation of the batch, which can be reverted by renaming
+// the file back.
+type fileStep struct {
+	from    string
+	to      string
+	deleted string // name of the deleted file
+}
+
+// batchFiles executes file operations of the batch. Deleted files are moved out of the
+// user files first, so they can be restored if the batch fails, and removed by commit.
+// Within the transaction, the operations are reverted by aborting it instead.
+type batchFiles struct {
+	app   *BasicApp
+	tx    *mongoTransaction
+	uid   string
+	id    string
+	steps []fileStep
+}
+
+// execute runs the file operation.
+func (files *batchFiles) execute(operation batchOperation) error {
+	if validateKey(operation.Name) != nil {
+		return errUnsupportedBatch
+	}
+	from := files.uid + ":" + operation.Name
+	step := fileStep{from: from}
+	switch operation.Op {
+	case "file_rename":
+		if validateKey(operation.To) != nil {
+			return errUnsupportedBatch
+		}
+		step.to = files.uid + ":" + operation.To
+		count, err := files.app.Coll.Files.Files.Find(bson.M{"filename": step.to}).Count()
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return errFileExists
+		}
+	case "file_delete":
+		step.to = "batch:" + files.id + ":" + from
+		step.deleted = operation.Name
+	}
+
+	err := files.rename(step.from, step.to)
+	if err == mgo.ErrNotFound {
+		return errNoSuchFile
+	}
+	if mgo.IsDup(err) {
+		return errFileExists
+	}
+	if err != nil {
+		return err
+	}
+	files.steps = append(files.steps, step)
+	return nil
+}
+
+func (files *batchFiles) rename(from string, to string) error {
+	selector := bson.M{"filename": from}
+	update := bson.M{"$set": bson.M{"filename": to}}
+	if files.tx != nil {
+		return files.tx.update(files.app.Coll.Files.Files, selector, update)
+	}
+	return files.app.Coll.Files.Files.Update(selector, update)
+}
+
+// rollback reverts the executed file operations in reverse order.
+func (files *batchFiles) rollback() error {
+	if files.tx != nil {
+		files.steps = nil
+		return files.tx.abort()
+	}
+	for i := len(files.steps) - 1; i >= 0; i-- {
+		step := files.steps[i]
+		err := files.rename(step.to, step.from)
+		if err != nil {
+			return err
+		}
+	}
+	files.steps = nil
+	return nil
+}
+
+// commit removes the deleted files along with the links to them, moves the links of the
+// renamed files and reports the file changes. The batch is applied already, so errors are
+// only logged.
+func (files *batchFiles) commit() {
+	app := files.app
+	objectUID := bson.ObjectIdHex(files.uid)
+	logError := func(err error) {
+		if err != nil {
+			app.Iris.Logger().Error(err)
+		}
+	}
+	for _, step := range files.steps {
+		if step.deleted != "" {
+			logError(app.removeStoredUserFile(objectUID, step.deleted, step.to))
+			continue
+		}
+
+		fromName := step.from[len(files.uid)+1:]
+		toName := step.to[len(files.uid)+1:]
+		logError(app.removeThumbnails(step.from))
+		_, err := app.Coll.Links.UpdateAll(bson.M{"uid": objectUID, "file": fromName}, bson.M{
+			"$set": bson.M{"file": toName},
+		})
+		logError(err)
+		logError(app.recordFileSync(objectUID, fromName, true))
+		logError(app.recordFileSync(objectUID, toName, false))
+		app.publishFileEvent(objectUID, "file_delete", fromName)
+		app.publishFileEvent(objectUID, "file_upload", toName)
+	}
+}
+
+// SweepBatchFiles restores the files deleted by the batches which were never finished, e.g.
+// because the server stopped in the middle of them. It's not known whether the data of such
+// batch was written, so the files are moved back under their names, unless the names are
+// taken already, in which case they are removed. Batches started less than an hour ago are
+// left alone.
+//
+// It returns number of restored or removed files.
+func (app *BasicApp) SweepBatchFiles() (int, error) {
+	iter := app.Coll.Files.Files.Find(bson.M{"filename": bson.M{"$regex": "^batch:"}}).
+		Select(bson.M{"filename": 1, "length": 1}).
+		Iter()
+
+	swept := 0
+	var file storedFile
+	for iter.Next(&file) {
+		// batch:{batch id}:{uid}:{name}
+		parts := strings.SplitN(file.Filename, ":", 4)
+		if len(parts) != 4 || !bson.IsObjectIdHex(parts[1]) || !bson.IsObjectIdHex(parts[2]) ||
+			time.Since(bson.ObjectIdHex(parts[1]).Time()) < batchOrphanAge {
+			file = storedFile{}
+			continue
+		}
+		err := app.Coll.Files.Files.UpdateId(file.ID, bson.M{
+			"$set": bson.M{"filename": parts[2] + ":" + parts[3]},
+		})
+		if mgo.IsDup(err) {
+			err = app.removeStoredFileId(file.ID)
+			if err == nil {
+				err = app.trackFileUsage(bson.ObjectIdHex(parts[2]), -file.Length, -1)
+			}
+		}
+		if err == nil {
+			swept++
+		} else if err != mgo.ErrNotFound { // finished meanwhile
+			iter.Close()
+			return swept, err
+		}
+		file = storedFile{}
+	}
+	return swept, iter.Close()
+}
+
+// sweepBatchFilesLoop runs SweepBatchFiles periodically.
+func (app *BasicApp) sweepBatchFilesLoop() {
+	for range time.Tick(batchSweepInterval) {
+		_, err := app.SweepBatchFiles()
+		if err != nil {
+			app.Iris.Logger().Error(err)
+		}
+	}
+}
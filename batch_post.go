@@ -0,0 +1,251 @@
+package basicserver
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeBatchPost serves
+// Method:   POST
+// Resource: http://localhost/api/batch
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Content-Type: application/json
+//		Authorization: Bearer {token}
+//
+// Runs ordered list of user data and file operations all-or-nothing. Sample request to be
+// `POST`ed as `application/json`:
+//
+//    [
+//      { "op": "set", "key": "profile.avatar", "value": "new.jpg" },
+//      { "op": "unset", "key": "profile.avatarDraft" },
+//      { "op": "patch", "patch": [ { "op": "test", "path": "/profile/name", "value": "Nick" } ] },
+//      { "op": "file_rename", "name": "upload.jpg", "to": "new.jpg" },
+//      { "op": "file_delete", "name": "old.jpg" }
+//    ]
+//
+// Keys are dotted paths, the same as in POST /api/data, and patches are RFC 6902 JSON
+// Patches, the same as in PATCH /api/data. Data operations are applied to the default
+// namespace user data in order and written at once, so they are atomic on their own.
+// File operations are executed first, with the deleted files kept aside until the data
+// is written. If anything fails, none of the operations is applied. Links to the
+// deleted files are removed and links to the renamed files follow them once the batch is
+// applied.
+//
+// If Mongo server is a replica set member of MongoDB 4.0 or newer, the file operations and
+// the data write run in a multi-document transaction, which is aborted if anything fails.
+// On a standalone server, the executed file operations are reverted instead. Such batch is
+// all-or-nothing, but not isolated: other requests might see the renamed files before the
+// data is written, or the files of the batch which is being reverted. If the server stops
+// in the middle of the batch, files deleted by it are restored by `app.SweepBatchFiles()`
+// later, whether the data was written or not.
+//
+// Optional `If-Match` header makes the batch conditional, the same as in POST /api/data.
+//
+// If everything goes well, then this will return status code `200`, `ETag` header with
+// the state version and `application/json` response with results of the operations:
+//
+//    {
+//      "results": [
+//        { "status": "ok" },
+//        { "status": "ok" },
+//        { "status": "ok" },
+//        { "status": "ok" },
+//        { "status": "ok" }
+//      ]
+//    }
+//
+// In case of failed operation, this will return status code `400`, `404` (no such file),
+// `409` (failed patch test or existing file) or `422` (missing patch path) and
+// `application/json` response with the failed operation and none of the operations applied:
+//
+//    {
+//      "error": "No Such File",
+//      "index": 4,
+//      "results": [
+//        { "status": "aborted" },
+//        { "status": "aborted" },
+//        { "status": "aborted" },
+//        { "status": "aborted" },
+//        { "status": "failed", "error": "No Such File" }
+//      ]
+//    }
+//
+// In case of error, this will return status code `400`, `412`, `413`, `422` or `500`
+// and `text/plain` error message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeBatchPost() iris.Handler {
+	return func(ctx iris.Context) {
+		var operations []batchOperation
+		err := ctx.ReadJSON(&operations)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			return
+		}
+		if len(operations) == 0 {
+			err = errUnsupportedBatch
+		} else {
+			err = validateKeysCount(len(operations))
+		}
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		uid := ctx.Values().Get("uid").(string)
+		objectUID := bson.ObjectIdHex(uid)
+
+		hasData := false
+		for _, operation := range operations {
+			if !operation.isFileOperation() {
+				hasData = true
+			}
+		}
+		ifMatch := parseStateCondition(ctx.GetHeader("If-Match"), false)
+		files := &batchFiles{app: app, uid: uid, id: bson.NewObjectId().Hex()}
+		defer func() { files.tx.close() }()
+
+		for attempt := 1; ; attempt++ {
+			if attempt == 1 || files.tx != nil {
+				files.tx.close()
+				files.tx, err = app.startTransaction()
+				if err != nil {
+					app.HandleError(err, ctx, iris.StatusInternalServerError)
+					return
+				}
+			}
+
+			var state State
+			err = app.Coll.States.FindId(objectUID).One(&state)
+			if err == nil {
+				err = app.openState(&state, nil)
+			}
+			if err != nil && err.Error() != "not found" {
+				app.rollbackBatch(files, err, ctx, len(operations))
+				return
+			}
+			if ifMatch != nil && !ifMatch.matches(state.Version) {
+				app.rollbackBatch(files, errPreconditionFailed, ctx, len(operations))
+				return
+			}
+			data, err := normalizeJSON(state.Data)
+			if err != nil {
+				app.rollbackBatch(files, err, ctx, len(operations))
+				return
+			}
+
+			// data operations are checked before any file is touched
+			result, update, err := applyBatchData(data, operations)
+			var updateInput bson.M
+			if err == nil && hasData {
+				updateInput, err = update.build(result)
+				if err != nil {
+					err = &batchError{index: firstDataOperation(operations), err: err}
+				}
+			}
+			// without transaction, the file operations are kept for the next attempts
+			if err == nil && (attempt == 1 || files.tx != nil) {
+				for i, operation := range operations {
+					if operation.isFileOperation() {
+						err = files.execute(operation)
+						if err != nil {
+							err = &batchError{index: i, err: err}
+							break
+						}
+					}
+				}
+			}
+			if err != nil {
+				app.rollbackBatch(files, err, ctx, len(operations))
+				return
+			}
+
+			written := &state
+			if hasData {
+				condition := &stateCondition{versions: []int64{state.Version}}
+				written, err = app.updateStateIn(files.tx, objectUID, "", updateInput, condition, requestID(ctx))
+				if err == errPreconditionFailed && ifMatch == nil && attempt < maxStateAttempts {
+					if files.tx != nil { // the file operations run again in the next one
+						err = files.rollback()
+						if err != nil {
+							app.HandleError(err, ctx, iris.StatusInternalServerError)
+							return
+						}
+					}
+					continue
+				}
+			}
+			if err == nil {
+				err = files.tx.commit()
+			}
+			if err != nil {
+				app.rollbackBatch(files, err, ctx, len(operations))
+				return
+			}
+			ctx.Header("ETag", written.ETag())
+			files.commit()
+
+			results := make([]batchResult, len(operations))
+			for i := range results {
+				results[i].Status = "ok"
+			}
+			ctx.JSON(iris.Map{"results": results})
+			return
+		}
+	}
+}
+
+// firstDataOperation returns index of the first data operation of the batch.
+func firstDataOperation(operations []batchOperation) int {
+	for i, operation := range operations {
+		if !operation.isFileOperation() {
+			return i
+		}
+	}
+	return 0
+}
+
+// rollbackBatch reverts file operations of the failed batch and writes the error. Errors
+// which don't belong to any of the operations are handled the same as state write errors.
+func (app *BasicApp) rollbackBatch(files *batchFiles, err error, ctx iris.Context, count int) {
+	rollbackErr := files.rollback()
+	if rollbackErr != nil {
+		app.HandleError(rollbackErr, ctx, iris.StatusInternalServerError)
+		return
+	}
+	if failed, ok := err.(*batchError); ok {
+		app.writeBatchError(failed, count, ctx)
+		return
+	}
+	app.handleStateError(err, ctx)
+}
+
+// writeBatchError writes response of the batch with failed operation.
+func (app *BasicApp) writeBatchError(failed *batchError, count int, ctx iris.Context) {
+	status := iris.StatusBadRequest
+	switch failed.err {
+	case errNoSuchFile:
+		status = iris.StatusNotFound
+	case errFileExists, errPatchTestFailed:
+		status = iris.StatusConflict
+	case errPatchNoPath:
+		status = iris.StatusUnprocessableEntity
+	}
+	app.HandleError(failed, ctx, status)
+
+	results := make([]batchResult, count)
+	for i := range results {
+		results[i].Status = "aborted"
+	}
+	results[failed.index] = batchResult{Status: "failed", Error: failed.Error()}
+	ctx.JSON(iris.Map{
+		"error":   failed.Error(),
+		"index":   failed.index,
+		"results": results,
+	})
+}
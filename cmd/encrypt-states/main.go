@@ -0,0 +1,75 @@
+// Command encrypt-states encrypts basicserver user states stored before the encryption was
+// enabled, and wraps user data keys with the current master key after its rotation:
+//
+//    ENCRYPTION_KEYS=first:...,second:... encrypt-states -mongo mongodb://127.0.0.1:27017/project \
+//        -key-id second
+//
+// Master keys are base64 encoded 32 bytes long keys by their ids, the same as
+// `EncryptionKeys` setting. After rotation, the previous master key is still needed to
+// unwrap the data keys, and can be removed from the settings once it's done. The server may
+// keep running meanwhile.
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/bonnevoyager/basicserver"
+)
+
+func main() {
+	mongoString := flag.String("mongo", "", "MongoDB connection string")
+	keyID := flag.String("key-id", "", "id of the current master key")
+	flag.Parse()
+
+	keys, err := parseKeys(os.Getenv("ENCRYPTION_KEYS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *mongoString == "" || *keyID == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	app := basicserver.CreateApp(&basicserver.Settings{
+		MongoString:     *mongoString,
+		ServerPort:      "0", // the server is not started
+		EncryptionKeys:  keys,
+		EncryptionKeyID: *keyID,
+	})
+
+	encrypted, err := app.EncryptStates()
+	log.Printf("encrypted %d states", encrypted)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rewrapped, err := app.RewrapDataKeys()
+	log.Printf("rewrapped %d data keys", rewrapped)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseKeys parses comma separated `id:key` pairs of base64 encoded master keys.
+func parseKeys(value string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for _, pair := range strings.Split(value, ",") {
+		if pair == "" {
+			continue
+		}
+		i := strings.Index(pair, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid key %q, expected id:key", pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(pair[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		keys[pair[:i]] = key
+	}
+	return keys, nil
+}
@@ -0,0 +1,61 @@
+// Command migrate-files copies contents of the stored files between basicserver file stores,
+// e.g. from GridFS to S3 bucket:
+//
+//    S3_ACCESS_KEY=... S3_SECRET_KEY=... migrate-files -mongo mongodb://127.0.0.1:27017/project \
+//        -from gridfs -to s3 -s3-endpoint http://localhost:9000 -s3-bucket files
+//
+// Stores are "gridfs", "disk" or "s3". Directory and bucket options apply to whichever of the
+// stores uses them. The server should be stopped while the files are copied.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/bonnevoyager/basicserver"
+	"github.com/globalsign/mgo"
+)
+
+func main() {
+	mongoString := flag.String("mongo", "", "MongoDB connection string")
+	from := flag.String("from", "gridfs", "source store: gridfs, disk or s3")
+	to := flag.String("to", "", "target store: gridfs, disk or s3")
+	dir := flag.String("dir", "", "directory of the disk store")
+	s3 := basicserver.S3Settings{
+		AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_SECRET_KEY"),
+	}
+	flag.StringVar(&s3.Endpoint, "s3-endpoint", "", "URL of the S3 service")
+	flag.StringVar(&s3.Region, "s3-region", "", "region of the S3 bucket")
+	flag.StringVar(&s3.Bucket, "s3-bucket", "", "name of the S3 bucket")
+	flag.StringVar(&s3.Prefix, "s3-prefix", "", "prefix of the S3 object keys")
+	flag.Parse()
+
+	if *mongoString == "" || *to == "" || *from == *to {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	session, err := mgo.Dial(*mongoString)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer session.Close()
+	db := session.DB("")
+
+	source, err := basicserver.NewFileStore(db, basicserver.FileStorageSettings{Backend: *from, Dir: *dir, S3: s3})
+	if err != nil {
+		log.Fatal(err)
+	}
+	target, err := basicserver.NewFileStore(db, basicserver.FileStorageSettings{Backend: *to, Dir: *dir, S3: s3})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	copied, err := basicserver.MigrateFiles(db, source, target)
+	log.Printf("copied %d files", copied)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,144 @@
+package basicserver
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeCollectionGet serves
+// Method:   GET
+// Resource: http://localhost/api/collections/{name:string}
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Authorization: Bearer {token}
+//
+// Records are queried with following optional parameters:
+//
+//    `filter` JSON object of record data paths and their values or conditions, e.g.
+//      {"done": false, "priority": {"$gte": 2}}. Supported operators are `$eq`, `$ne`,
+//      `$gt`, `$gte`, `$lt`, `$lte`, `$in`, `$nin` and `$exists`. Conditions can be
+//      combined with `$and`, `$or` and `$nor`.
+//    `sort` comma separated record data paths, "id", "created_at" or "updated_at".
+//      Paths prefixed with "-" are sorted in descending order, e.g. "-priority,title".
+//      Records are sorted by id by default.
+//    `fields` comma separated record data paths to be returned, e.g. "title,done"
+//    `limit` number of records to be returned, 20 by default and 100 at most
+//    `cursor` cursor of the next page received with the previous page
+//
+// Paths are dotted, the same as keys in POST /api/data. Sort fields should hold values
+// of the same type, since Mongo compares values of different types by their type only.
+//
+// If everything goes well, then this will return status code `200` and `application/json`
+// response with the records. Cursor of the next page is returned if there are more records
+// and it has to be used with the same `sort` parameter:
+//
+//    {
+//      "records": [
+//        {
+//          "id": "5c90a7cde3fd3c4e4c7d4b5f",
+//          "data": {
+//            "title": "Buy milk",
+//            "done": false
+//          },
+//          "created_at": "2019-03-19T10:00:00Z",
+//          "updated_at": "2019-03-19T10:00:00Z"
+//        }
+//      ],
+//      "cursor": "..."
+//    }
+//
+// In case of error, this will return status code `400` or `500` and `text/plain` error
+// message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeCollectionGet() iris.Handler {
+	return func(ctx iris.Context) {
+		collection, err := recordsCollectionName(ctx)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		uid := ctx.Values().Get("uid").(string)
+		selector := recordsSelector(bson.ObjectIdHex(uid), collection)
+
+		sort := ctx.URLParam("sort")
+		fields, err := parseRecordsSort(sort)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		var conditions []bson.M
+		if filter := ctx.URLParam("filter"); filter != "" {
+			condition, err := parseRecordsFilter(filter)
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusBadRequest)
+				ctx.WriteString(err.Error())
+				return
+			}
+			conditions = append(conditions, condition)
+		}
+		if cursor := ctx.URLParam("cursor"); cursor != "" {
+			condition, err := parseRecordsCursor(app.Settings.Secret, cursor, sort, fields)
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusBadRequest)
+				ctx.WriteString(err.Error())
+				return
+			}
+			conditions = append(conditions, condition)
+		}
+		if len(conditions) > 0 {
+			selector["$and"] = conditions
+		}
+
+		limit := ctx.URLParamIntDefault("limit", defaultRecordsLimit)
+		if limit <= 0 {
+			limit = defaultRecordsLimit
+		}
+		if limit > maxRecordsLimit {
+			limit = maxRecordsLimit
+		}
+
+		// one more record tells whether there is a next page
+		query := app.Coll.Records.Find(selector).Sort(mongoSort(fields)...).Limit(limit + 1)
+		if keys := ctx.URLParam("fields"); keys != "" {
+			projection, err := recordsProjection(keys, fields)
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusBadRequest)
+				ctx.WriteString(err.Error())
+				return
+			}
+			query = query.Select(projection)
+		}
+
+		records := []Record{}
+		err = query.All(&records)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		response := iris.Map{}
+		if len(records) > limit {
+			records = records[:limit]
+			cursor, err := encodeRecordsCursor(app.Settings.Secret, sort, fields, &records[limit-1])
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+				return
+			}
+			response["cursor"] = cursor
+		}
+		for i := range records {
+			records[i] = records[i].decoded()
+		}
+		response["records"] = records
+
+		ctx.JSON(response)
+	}
+}
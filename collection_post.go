@@ -0,0 +1,92 @@
+package basicserver
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeCollectionPost serves
+// Method:   POST
+// Resource: http://localhost/api/collections/{name:string}
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Content-Type: application/json
+//		Authorization: Bearer {token}
+//
+// Collection names consist of up to 64 letters, digits, "_" and "-" characters.
+// Collections don't need to be created upfront.
+//
+// Sample request to be `POST`ed to the /api/collections/todos resource as `application/json`:
+//
+//    {
+//      "title": "Buy milk",
+//      "done": false
+//    }
+//
+// If everything goes well, then this will return status code `200` and `application/json`
+// response with the created record and it's generated id:
+//
+//    {
+//      "id": "5c90a7cde3fd3c4e4c7d4b5f",
+//      "data": {
+//        "title": "Buy milk",
+//        "done": false
+//      },
+//      "created_at": "2019-03-19T10:00:00Z",
+//      "updated_at": "2019-03-19T10:00:00Z"
+//    }
+//
+// In case of error, this will return status code `400` or `500` and `text/plain` error
+// message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeCollectionPost() iris.Handler {
+	return func(ctx iris.Context) {
+		collection, err := recordsCollectionName(ctx)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		var input bson.M
+		err = ctx.ReadJSON(&input)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			return
+		}
+		if input == nil {
+			input = bson.M{}
+		}
+		err = validateDataValue(input, 0)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		uid := ctx.Values().Get("uid").(string)
+
+		now := time.Now()
+		record := Record{
+			ID:         bson.NewObjectId(),
+			UID:        bson.ObjectIdHex(uid),
+			Collection: collection,
+			Data:       encodeKeys(input).(bson.M),
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		err = app.Coll.Records.Insert(record)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		ctx.JSON(record.decoded())
+	}
+}
@@ -2,32 +2,52 @@ package basicserver
 
 import (
 	"errors"
-	"time"
 
 	"github.com/globalsign/mgo/bson"
 	"github.com/kataras/iris"
 )
 
+var errUnsupportedInput = errors.New("Unsupported Input")
+
 // ServeDataDelete serves
 // Method:   DELETE
 // Resource: http://localhost/api/data
+// Resource: http://localhost/api/data/{namespace:string}
 //
 // This resource requires `Authorization` header, e.g.:
 //
 //		Content-Type: application/json
 //		Authorization: Bearer {token}
 //
+// Named data namespace might be given, in which case the data is stored in a separate
+// document with it's own version. Namespace names consist of up to 64 letters, digits,
+// "_" and "-" characters. Name "default" stands for the data served by /api/data.
+//
 // Sample requests needs to be send as DELETE to the /api/data resource as application/json:
 //
-// This will remove all they keys contained in the array:
+// This will remove all they keys contained in the array. Keys are dotted paths with the
+// same rules and limits as in POST /api/data:
 //
-//    [ "-12198394893", "23749713845" ]
+//    [ "-12198394893", "23749713845", "profile.avatar" ]
 //
 // Bellow will removed all the user data from user storage
 //
 //    true
 //
-// If everything goes well, then this will return status code `200` and no response body.
+// Optional `If-Match` header with the `ETag` received from GET /api/data makes the removal
+// conditional:
+//
+//		If-Match: "12"
+//
+// If everything goes well, then this will return status code `200`, `ETag` header with
+// the new state version and no response body.
+//
+// In case of `If-Match` header which doesn't match the current state version, this will
+// return status code `412`.
+//
+// In case of configured schema which the data after the write doesn't match, this will
+// return status code `422` and `application/json` response with the violations, the same
+// as in POST /api/data.
 //
 // In case of error, this will return status code `400` or `500` and `text/plain` error
 // message (e.g. "Unsupported Input") as a response.
@@ -47,28 +67,54 @@ func (app *BasicApp) ServeDataDelete() iris.Handler {
 		uid := ctx.Values().Get("uid").(string)
 		objectUID := bson.ObjectIdHex(uid)
 
-		unsetInput := make(bson.M)
-		switch i := input.(type) {
-		case []interface{}:
-			for _, v := range i {
-				unsetInput["data."+v.(string)] = ""
-			}
-		case bool:
-			unsetInput["data"] = ""
-		default:
-			err = errors.New("Unsupported Input")
+		namespace, err := stateNamespace(ctx)
+		if err != nil {
 			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
 			return
 		}
-		updateInput := bson.M{
-			"$set":   bson.M{"updated_at": time.Now()},
-			"$unset": unsetInput,
+
+		updateInput, err := dataUnsetUpdate(input)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
 		}
 
-		_, err = app.Coll.States.UpsertId(objectUID, updateInput)
+		condition := parseStateCondition(ctx.GetHeader("If-Match"), false)
+		_, err = app.writeState(ctx, objectUID, namespace, updateInput, condition)
 		if err != nil {
-			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			app.handleStateError(err, ctx)
 			return
 		}
 	}
 }
+
+// dataUnsetUpdate returns Mongo update which removes the dotted path keys given in the
+// input array, or the whole user data in case of `true`.
+func dataUnsetUpdate(input interface{}) (bson.M, error) {
+	unsetInput := make(bson.M)
+	switch i := input.(type) {
+	case []interface{}:
+		err := validateKeysCount(len(i))
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range i {
+			key, ok := v.(string)
+			if !ok {
+				return nil, errUnsupportedInput
+			}
+			path, err := parseDataPath(key)
+			if err != nil {
+				return nil, err
+			}
+			unsetInput[dataPath(path)] = ""
+		}
+	case bool:
+		unsetInput["data"] = ""
+	default:
+		return nil, errUnsupportedInput
+	}
+	return bson.M{"$unset": unsetInput}, nil
+}
@@ -1,6 +1,9 @@
 package basicserver
 
 import (
+	"net/http"
+	"time"
+
 	"github.com/globalsign/mgo/bson"
 	"github.com/kataras/iris"
 )
@@ -8,19 +11,47 @@ import (
 // ServeDataGet serves
 // Method:   GET
 // Resource: http://localhost/api/data
+// Resource: http://localhost/api/data/{namespace:string}
 //
 // This resource requires `Authorization` header, e.g.:
 //
 //		Authorization: Bearer {token}
 //
-// If everything goes well, then this will return status code `200` and `application/json`
-// response with the stored data:
+// Named data namespace might be given, in which case the data is stored in a separate
+// document with it's own version. Namespace names consist of up to 64 letters, digits,
+// "_" and "-" characters. Name "default" stands for the data served by /api/data. Single
+// segment is always a namespace, even if it's not created yet, values of the default
+// namespace are served by GET /api/data/default/{path:string}.
+//
+// If everything goes well, then this will return status code `200`, `ETag` header with
+// the state version and `application/json` response with the stored data:
 //
 //    {
 //      "foo": "bar",
 //      "bar": "foo"
 //    }
 //
+// Optional `keys` query parameter limits the response to the given comma separated
+// dotted paths, e.g. `/api/data?keys=settings,profile.avatar` returns:
+//
+//    {
+//      "settings": { "theme": "dark" },
+//      "profile": { "avatar": "foo.jpg" }
+//    }
+//
+// Keys written with time-to-live are listed in `X-Data-TTL` header along with their remaining
+// seconds, in the same format as `ttl` parameter of POST /api/data:
+//
+//		X-Data-TTL: draft=540,token=20
+//
+// Expired keys are never returned.
+//
+// Optional `If-None-Match` header with previously received `ETag` allows cheap polling.
+// If the state didn't change meanwhile, this will return status code `304` and no
+// response body:
+//
+//		If-None-Match: "12"
+//
 // In case of error, this will return status code `400` or `500` and `text/plain` error
 // message as a response.
 //
@@ -32,17 +63,63 @@ func (app *BasicApp) ServeDataGet() iris.Handler {
 		uid := ctx.Values().Get("uid").(string)
 		objectUID := bson.ObjectIdHex(uid)
 
-		var state State
-		err := app.Coll.States.FindId(objectUID).One(&state)
+		namespace, err := stateNamespace(ctx)
 		if err != nil {
-			if err.Error() == "not found" {
-				ctx.JSON(State{})
-			} else {
-				app.HandleError(err, ctx, iris.StatusInternalServerError)
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		query := app.Coll.States.Find(stateSelector(objectUID, namespace))
+		var paths [][]string
+		if keys := ctx.URLParam("keys"); keys != "" {
+			parts := splitEscaped(keys, ',')
+			err = validateKeysCount(len(parts))
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusBadRequest)
+				ctx.WriteString(err.Error())
+				return
+			}
+			for _, key := range parts {
+				path, err := parseDataPath(key)
+				if err != nil {
+					app.HandleError(err, ctx, iris.StatusBadRequest)
+					ctx.WriteString(err.Error())
+					return
+				}
+				paths = append(paths, path)
 			}
+			query = query.Select(dataProjection(paths))
+		}
+
+		var state State
+		err = query.One(&state)
+		if err == nil {
+			err = app.openState(&state, paths)
+		}
+		if err != nil && err.Error() != "not found" {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		ctx.Header("ETag", state.ETag())
+		if condition := parseStateCondition(ctx.GetHeader("If-None-Match"), true); condition != nil &&
+			condition.matches(state.Version) {
+			ctx.StatusCode(iris.StatusNotModified)
 			return
 		}
 
-		ctx.JSON(state.Data)
+		if err != nil { // state might not be existing yet
+			ctx.JSON(State{})
+			return
+		}
+		if !state.UpdatedAt.IsZero() {
+			ctx.Header("Last-Modified", state.UpdatedAt.UTC().Format(http.TimeFormat))
+		}
+		if ttl := formatTTL(state.Expires, state.Data, time.Now()); ttl != "" {
+			ctx.Header("X-Data-TTL", ttl)
+		}
+
+		ctx.JSON(decodeKeys(state.Data))
 	}
 }
@@ -0,0 +1,137 @@
+package basicserver
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeDataOpsPost serves
+// Method:   POST
+// Resource: http://localhost/api/ops
+// Resource: http://localhost/api/data/{namespace:string}/ops
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Content-Type: application/json
+//		Authorization: Bearer {token}
+//
+// Operations are applied to the user data atomically, so concurrent clients don't lose
+// each other's updates. Sample request to be `POST`ed as `application/json`:
+//
+//    [
+//      { "op": "inc", "path": "counters.visits", "value": 1 },
+//      { "op": "addToSet", "path": "tags", "value": "work" },
+//      { "op": "setIfAbsent", "path": "profile.createdAt", "value": "2019-03-19" }
+//    ]
+//
+// Paths are dotted, the same as keys in POST /api/data, and can't overlap. Following
+// operations are supported:
+//
+//    `inc` increases the number by the value, 1 by default
+//    `dec` decreases the number by the value, 1 by default
+//    `min` sets the value if it's lower than the stored one
+//    `max` sets the value if it's greater than the stored one
+//    `push` appends the value to the array
+//    `pull` removes all the values equal to the value from the array
+//    `addToSet` appends the value to the array unless it's there already
+//    `setIfAbsent` sets the value unless the path exists already
+//
+// Set-if-absent operations require the state not to change between the check and the write,
+// otherwise the request is retried.
+//
+// Optional `If-Match` header makes the operations conditional, the same as in POST /api/data.
+//
+// If everything goes well, then this will return status code `200`, `ETag` header with
+// the new state version and `application/json` response with the new values of the paths:
+//
+//    {
+//      "counters.visits": 12,
+//      "tags": [ "home", "work" ],
+//      "profile.createdAt": "2019-03-01"
+//    }
+//
+// In case of operation which doesn't fit the stored value, e.g. increase of a string, this
+// will return status code `400` and `text/plain` error message as a response.
+//
+// In case of error, this will return status code `400`, `412`, `413`, `422` or `500`
+// and `text/plain` error message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeDataOpsPost() iris.Handler {
+	return func(ctx iris.Context) {
+		var operations []atomicOperation
+		err := ctx.ReadJSON(&operations)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			return
+		}
+
+		uid := ctx.Values().Get("uid").(string)
+		objectUID := bson.ObjectIdHex(uid)
+
+		namespace, err := stateNamespace(ctx)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		err = parseAtomicOperations(operations)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		var absentPaths [][]string
+		for _, operation := range operations {
+			if operation.Op == "setIfAbsent" {
+				absentPaths = append(absentPaths, operation.path)
+			}
+		}
+		ifMatch := parseStateCondition(ctx.GetHeader("If-Match"), false)
+
+		for attempt := 1; ; attempt++ {
+			var current State
+			condition := ifMatch
+			if len(absentPaths) > 0 {
+				err = app.Coll.States.Find(stateSelector(objectUID, namespace)).
+					Select(dataProjection(absentPaths)).
+					One(&current)
+				if err == nil {
+					err = app.openState(&current, absentPaths)
+				}
+				if err != nil && err.Error() != "not found" {
+					app.HandleError(err, ctx, iris.StatusInternalServerError)
+					return
+				}
+				if ifMatch != nil && !ifMatch.matches(current.Version) {
+					app.handleStateError(errPreconditionFailed, ctx)
+					return
+				}
+				condition = &stateCondition{versions: []int64{current.Version}}
+			}
+
+			update := atomicUpdate(operations, current.Data)
+			if len(update) == 0 { // all the values exist already
+				ctx.Header("ETag", current.ETag())
+				ctx.JSON(atomicResult(operations, current.Data))
+				return
+			}
+
+			state, err := app.writeState(ctx, objectUID, namespace, update, condition)
+			if err == errPreconditionFailed && ifMatch == nil && attempt < maxStateAttempts {
+				continue
+			}
+			if err != nil {
+				app.handleStateError(err, ctx)
+				return
+			}
+
+			ctx.JSON(atomicResult(operations, state.Data))
+			return
+		}
+	}
+}
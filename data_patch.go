@@ -0,0 +1,166 @@
+package basicserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+const jsonPatchContentType = "application/json-patch+json"
+const mergePatchContentType = "application/merge-patch+json"
+const maxPatchAttempts = 3
+
+// ServeDataPatch serves
+// Method:   PATCH
+// Resource: http://localhost/api/data
+// Resource: http://localhost/api/data/{namespace:string}
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Content-Type: application/json-patch+json
+//		Authorization: Bearer {token}
+//
+// Named data namespace might be given, in which case the data is stored in a separate
+// document with it's own version. Namespace names consist of up to 64 letters, digits,
+// "_" and "-" characters. Name "default" stands for the data served by /api/data.
+//
+// Patch format is chosen by `Content-Type` header. With `application/json-patch+json`
+// request body is RFC 6902 JSON Patch:
+//
+//    [
+//      { "op": "test", "path": "/profile/name", "value": "foo" },
+//      { "op": "replace", "path": "/profile/name", "value": "bar" },
+//      { "op": "add", "path": "/list/-", "value": "baz" }
+//    ]
+//
+// With `application/merge-patch+json` request body is RFC 7396 JSON Merge Patch, where
+// `null` values remove the keys:
+//
+//    {
+//      "profile": { "name": "bar", "avatar": null }
+//    }
+//
+// The patch is translated to a single Mongo update. Optional `If-Match` header makes
+// the patch conditional, the same way as in POST /api/data.
+//
+// If everything goes well, then this will return status code `200`, `ETag` header with
+// the new state version and no response body.
+//
+// In case of `If-Match` header which doesn't match the current state version, this will
+// return status code `412`.
+//
+// In case of configured schema which the data after the write doesn't match, this will
+// return status code `422` and `application/json` response with the violations, the same
+// as in POST /api/data.
+//
+// In case of failed `test` operation, this will return status code `409` and no changes
+// are stored.
+//
+// In case of patch path which doesn't exist, this will return status code `422`.
+//
+// In case of other error, this will return status code `400`, `415` or `500` and `text/plain`
+// error message (e.g. "Unsupported Patch") as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeDataPatch() iris.Handler {
+	return func(ctx iris.Context) {
+		contentType, _, _ := mime.ParseMediaType(ctx.GetHeader("Content-Type"))
+		if contentType != jsonPatchContentType && contentType != mergePatchContentType {
+			err := errors.New("Unsupported Content-Type")
+			app.HandleError(err, ctx, iris.StatusUnsupportedMediaType)
+			ctx.WriteString("Unsupported Content-Type")
+			return
+		}
+
+		body, err := ioutil.ReadAll(ctx.Request().Body)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			return
+		}
+
+		uid := ctx.Values().Get("uid").(string)
+		objectUID := bson.ObjectIdHex(uid)
+
+		namespace, err := stateNamespace(ctx)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+		ifMatch := parseStateCondition(ctx.GetHeader("If-Match"), false)
+
+		// the patch is computed from the current state, so it is written only if the state
+		// didn't change meanwhile, otherwise it is computed again
+		for attempt := 1; ; attempt++ {
+			var state State
+			err = app.Coll.States.Find(stateSelector(objectUID, namespace)).One(&state)
+			if err == nil {
+				err = app.openState(&state, nil)
+			}
+			if err != nil && err.Error() != "not found" {
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+				return
+			}
+			if ifMatch != nil && !ifMatch.matches(state.Version) {
+				app.handleStateError(errPreconditionFailed, ctx)
+				return
+			}
+			data, err := normalizeJSON(state.Data)
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+				return
+			}
+
+			var result map[string]interface{}
+			var update *dataUpdate
+			if contentType == jsonPatchContentType {
+				var operations []jsonPatchOperation
+				err = json.Unmarshal(body, &operations)
+				if err == nil {
+					result, update, err = applyJSONPatch(data, operations)
+				}
+			} else {
+				var patch interface{}
+				err = json.Unmarshal(body, &patch)
+				if err == nil {
+					result, update, err = applyMergePatch(data, patch)
+				}
+			}
+			if err != nil {
+				switch err {
+				case errPatchTestFailed:
+					app.HandleError(err, ctx, iris.StatusConflict)
+				case errPatchNoPath:
+					app.HandleError(err, ctx, iris.StatusUnprocessableEntity)
+				default:
+					app.HandleError(err, ctx, iris.StatusBadRequest)
+				}
+				ctx.WriteString(err.Error())
+				return
+			}
+
+			updateInput, err := update.build(result)
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusBadRequest)
+				ctx.WriteString(err.Error())
+				return
+			}
+
+			condition := &stateCondition{versions: []int64{state.Version}}
+			_, err = app.writeState(ctx, objectUID, namespace, updateInput, condition)
+			if err == errPreconditionFailed && ifMatch == nil && attempt < maxPatchAttempts {
+				continue
+			}
+			if err != nil {
+				app.handleStateError(err, ctx)
+			}
+			return
+		}
+	}
+}
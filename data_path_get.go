@@ -0,0 +1,73 @@
+package basicserver
+
+import (
+	"errors"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeDataPathGet serves
+// Method:   GET
+// Resource: http://localhost/api/data/{namespace:string}/{path:string}
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Authorization: Bearer {token}
+//
+// The path is dotted path to the stored value, the same as keys in POST /api/data,
+// e.g. `/api/data/default/profile.avatar` for the default namespace.
+//
+// If everything goes well, then this will return status code `200`, `ETag` header with
+// the state version and `application/json` response with the stored value:
+//
+//    "foo.jpg"
+//
+// In case of path which doesn't exist, this will return status code `404` and `text/plain`
+// error message (e.g. "No Such Key") as a response.
+//
+// In case of error, this will return status code `400` or `500` and `text/plain` error
+// message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeDataPathGet() iris.Handler {
+	return func(ctx iris.Context) {
+		namespace, err := stateNamespace(ctx)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+		path, err := parseDataPath(ctx.Params().Get("path"))
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		uid := ctx.Values().Get("uid").(string)
+		objectUID := bson.ObjectIdHex(uid)
+
+		var state State
+		err = app.Coll.States.Find(stateSelector(objectUID, namespace)).Select(dataProjection([][]string{path})).One(&state)
+		if err == nil {
+			err = app.openState(&state, [][]string{path})
+		}
+		if err != nil && err.Error() != "not found" {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		value, ok := getPathValue(state.Data, encodePath(path))
+		if !ok {
+			app.HandleError(errors.New("No Such Key"), ctx, iris.StatusNotFound)
+			ctx.WriteString("No Such Key")
+			return
+		}
+
+		ctx.Header("ETag", state.ETag())
+		ctx.JSON(decodeKeys(value))
+	}
+}
@@ -10,12 +10,17 @@ import (
 // ServeDataPost serves
 // Method:   POST
 // Resource: http://localhost/api/data
+// Resource: http://localhost/api/data/{namespace:string}
 //
 // This resource requires `Authorization` header, e.g.:
 //
 //		Content-Type: application/json
 //		Authorization: Bearer {token}
 //
+// Named data namespace might be given, in which case the data is stored in a separate
+// document with it's own version. Namespace names consist of up to 64 letters, digits,
+// "_" and "-" characters. Name "default" stands for the data served by /api/data.
+//
 // Sample request to be `POST`ed to the /api/data resource as `application/json`:
 //
 //    {
@@ -23,7 +28,46 @@ import (
 //      "bar": "foo"
 //    }
 //
-// If everything goes well, then this will return status code `200` and no response body.
+// Keys are dotted paths, so nested values can be set without rewriting the whole key.
+// Dots which are a part of the key need to be escaped with backslash:
+//
+//    {
+//      "profile.name": "foo",
+//      "files.avatar\\.jpg": "bar"
+//    }
+//
+// Keys can't be empty or contain null characters and are limited to 256 bytes. Data can be
+// nested up to 64 levels deep, counting both the path keys and the value, and up to 1000
+// keys can be set at once. Otherwise this will return status code `400` with error message
+// such as "Unsupported Key", "Key Too Long", "Data Nested Too Deep" or "Too Many Keys".
+//
+// Optional `ttl` query parameter sets time-to-live of the written top level keys as comma
+// separated `key=seconds` pairs with URL escaped keys, e.g. `/api/data?ttl=draft=600`.
+// Expired keys are not returned and get removed shortly after. Writing the key again
+// without `ttl` makes it persistent, while writes to it's nested paths keep it's
+// time-to-live. Invalid `ttl` or the one given for a key which isn't written results in
+// status code `400` and "Unsupported TTL" error message.
+//
+// Optional `If-Match` header with the `ETag` received from GET /api/data makes the write
+// conditional, so changes made meanwhile from another device are not overwritten:
+//
+//		If-Match: "12"
+//
+// If everything goes well, then this will return status code `200`, `ETag` header with
+// the new state version and no response body.
+//
+// In case of `If-Match` header which doesn't match the current state version, this will
+// return status code `412`.
+//
+// In case of configured `Schema` or `KeySchemas` settings which the data after the write
+// doesn't match, this will return status code `422` and `application/json` response with
+// JSON Pointers to the invalid values:
+//
+//    {
+//      "errors": [
+//        { "path": "/profile/age", "message": "expected number, but got string" }
+//      ]
+//    }
 //
 // In case of error, this will return status code `400` or `500` and `text/plain` error
 // message (e.g. "Incorrect Credentials") as a response.
@@ -43,16 +87,75 @@ func (app *BasicApp) ServeDataPost() iris.Handler {
 		uid := ctx.Values().Get("uid").(string)
 		objectUID := bson.ObjectIdHex(uid)
 
-		parsedInput := make(bson.M)
-		for key, value := range input {
-			parsedInput["data."+key] = value
+		namespace, err := stateNamespace(ctx)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		var ttl map[string]time.Duration
+		if value := ctx.URLParam("ttl"); value != "" {
+			ttl, err = parseTTL(value)
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusBadRequest)
+				ctx.WriteString(err.Error())
+				return
+			}
 		}
-		parsedInput["updated_at"] = time.Now()
 
-		_, err = app.Coll.States.UpsertId(objectUID, bson.M{"$set": parsedInput})
+		update, err := dataSetUpdate(input, ttl)
 		if err != nil {
-			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		condition := parseStateCondition(ctx.GetHeader("If-Match"), false)
+		_, err = app.writeState(ctx, objectUID, namespace, update, condition)
+		if err != nil {
+			app.handleStateError(err, ctx)
 			return
 		}
 	}
 }
+
+// dataSetUpdate returns Mongo update which sets the dotted path keys of the input, along
+// with time-to-live of the given top level keys.
+func dataSetUpdate(input bson.M, ttl map[string]time.Duration) (bson.M, error) {
+	err := validateKeysCount(len(input))
+	if err != nil {
+		return nil, err
+	}
+	writtenKeys := make(map[string]bool)
+
+	parsedInput := make(bson.M)
+	for key, value := range input {
+		path, err := parseDataPath(key)
+		if err == nil {
+			err = validateDataValue(value, len(path))
+		}
+		if err != nil {
+			return nil, err
+		}
+		parsedInput[dataPath(path)] = encodeKeys(value)
+		writtenKeys[path[0]] = true
+	}
+
+	update := bson.M{"$set": parsedInput}
+	var nextExpiry time.Time
+	for key, duration := range ttl {
+		if !writtenKeys[key] { // time-to-live is set only along with the value
+			return nil, errUnsupportedTTL
+		}
+		expireAt := time.Now().Add(duration)
+		parsedInput["expires."+encodeKey(key)] = expireAt
+		if nextExpiry.IsZero() || expireAt.Before(nextExpiry) {
+			nextExpiry = expireAt
+		}
+	}
+	if !nextExpiry.IsZero() {
+		update["$min"] = bson.M{"next_expiry": nextExpiry}
+	}
+	return update, nil
+}
@@ -0,0 +1,421 @@
+package basicserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+const encryptionKeySize = 32
+
+var (
+	errNoEncryptionKey      = errors.New("Encryption Key Not Available")
+	errInvalidEncryptedData = errors.New("Invalid Encrypted Data")
+)
+
+// DataKey is an entity of user data keys collection. User states are encrypted with
+// the user's own data key, which is stored wrapped by one of the master keys:
+//
+//	`UID` user uid
+//	`KeyID` id of the master key which wraps the data key
+//	`Key` wrapped data key
+//	`CreatedAt` time at which the data key was generated
+type DataKey struct {
+	UID       bson.ObjectId `bson:"_id"`
+	KeyID     string        `bson:"key_id"`
+	Key       []byte        `bson:"key"`
+	CreatedAt time.Time     `bson:"created_at"`
+}
+
+// checkEncryptionKeys checks the master keys given in the settings.
+func checkEncryptionKeys(settings *Settings) error {
+	for id, key := range settings.EncryptionKeys {
+		if len(key) != encryptionKeySize {
+			return errors.New("Encryption key " + id + " should be " + strconv.Itoa(encryptionKeySize) + " bytes long")
+		}
+	}
+	if settings.EncryptionKeyID != "" && settings.EncryptionKeys[settings.EncryptionKeyID] == nil {
+		return errors.New("Encryption key " + settings.EncryptionKeyID + " not found in EncryptionKeys")
+	}
+	return nil
+}
+
+// encryptionEnabled reports whether user states are encrypted on write.
+func (app *BasicApp) encryptionEnabled() bool {
+	return app.Settings.EncryptionKeyID != ""
+}
+
+// sealBytes encrypts the plaintext with AES-GCM. Random nonce is prepended to the result.
+// Additional data binds the ciphertext to its place, so it can't be moved elsewhere.
+func sealBytes(key []byte, plaintext []byte, additionalData string) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, []byte(additionalData)), nil
+}
+
+// openBytes decrypts ciphertext returned by sealBytes.
+func openBytes(key []byte, ciphertext []byte, additionalData string) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errInvalidEncryptedData
+	}
+	nonce := ciphertext[:gcm.NonceSize()]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext[gcm.NonceSize():], []byte(additionalData))
+	if err != nil {
+		return nil, errInvalidEncryptedData
+	}
+	return plaintext, nil
+}
+
+func dataKeyAdditionalData(objectUID bson.ObjectId) string {
+	return "data_key:" + objectUID.Hex()
+}
+
+// userDataKey returns unwrapped data key of the user. If the user has no data key yet and
+// `create` is true, a new one is generated and wrapped with the current master key.
+func (app *BasicApp) userDataKey(objectUID bson.ObjectId, create bool) ([]byte, error) {
+	var dataKey DataKey
+	err := app.Coll.DataKeys.FindId(objectUID).One(&dataKey)
+	if err != nil && err.Error() != "not found" {
+		return nil, err
+	}
+	if err == nil {
+		masterKey := app.Settings.EncryptionKeys[dataKey.KeyID]
+		if masterKey == nil {
+			return nil, errNoEncryptionKey
+		}
+		return openBytes(masterKey, dataKey.Key, dataKeyAdditionalData(objectUID))
+	}
+	if !create || !app.encryptionEnabled() {
+		return nil, errNoEncryptionKey
+	}
+
+	key := make([]byte, encryptionKeySize)
+	_, err = io.ReadFull(rand.Reader, key)
+	if err != nil {
+		return nil, err
+	}
+	keyID := app.Settings.EncryptionKeyID
+	wrapped, err := sealBytes(app.Settings.EncryptionKeys[keyID], key, dataKeyAdditionalData(objectUID))
+	if err != nil {
+		return nil, err
+	}
+	err = app.Coll.DataKeys.Insert(DataKey{
+		UID:       objectUID,
+		KeyID:     keyID,
+		Key:       wrapped,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		if mgo.IsDup(err) { // generated meanwhile by another request
+			return app.userDataKey(objectUID, false)
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// stateOwner returns uid of the user who owns the state.
+func stateOwner(state *State) bson.ObjectId {
+	if state.UID != "" {
+		return state.UID
+	}
+	return state.ID
+}
+
+func stateAdditionalData(objectUID bson.ObjectId, namespace string) string {
+	return "state:" + objectUID.Hex() + ":" + namespace
+}
+
+// encryptState returns user data of the state encrypted with the user data key.
+func (app *BasicApp) encryptState(objectUID bson.ObjectId, namespace string, data bson.M) ([]byte, error) {
+	key, err := app.userDataKey(objectUID, true)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := bson.Marshal(bson.M{"data": data})
+	if err != nil {
+		return nil, err
+	}
+	return sealBytes(key, plaintext, stateAdditionalData(objectUID, namespace))
+}
+
+// openState prepares read state for use: user data of the encrypted state is decrypted
+// and the expired keys are dropped. If the paths are given, only they are kept, the same
+// as with dataProjection.
+func (app *BasicApp) openState(state *State, paths [][]string) error {
+	defer state.dropExpired(time.Now())
+	if state.Encrypted == nil {
+		return nil
+	}
+	owner := stateOwner(state)
+	key, err := app.userDataKey(owner, false)
+	if err != nil {
+		return err
+	}
+	plaintext, err := openBytes(key, state.Encrypted, stateAdditionalData(owner, state.Namespace))
+	if err != nil {
+		return err
+	}
+	var decrypted struct {
+		Data bson.M `bson:"data"`
+	}
+	err = bson.Unmarshal(plaintext, &decrypted)
+	if err != nil {
+		return err
+	}
+	state.Data = decrypted.Data
+	if paths != nil {
+		state.Data = projectData(decrypted.Data, paths)
+	}
+	return nil
+}
+
+// projectData returns the paths of user data, the same as Mongo projection of dataProjection.
+func projectData(data bson.M, paths [][]string) bson.M {
+	result := bson.M{}
+	for _, path := range paths {
+		encoded := encodePath(path)
+		if value, ok := getPathValue(data, encoded); ok {
+			setPathValue(result, encoded, value)
+		}
+	}
+	return result
+}
+
+// storedStateUpdate returns Mongo update which stores the whole user data, encrypted or not,
+// instead of updating the data paths. The other fields of the update are kept.
+func (app *BasicApp) storedStateUpdate(objectUID bson.ObjectId, namespace string, update bson.M, data bson.M) (bson.M, error) {
+	stored := bson.M{}
+	for operator, fields := range update {
+		fieldsMap, ok := fields.(bson.M)
+		if !ok {
+			continue
+		}
+		operatorFields := bson.M{}
+		for field, value := range fieldsMap {
+			if _, ok := splitDataPath(field); !ok {
+				operatorFields[field] = value
+			}
+		}
+		if len(operatorFields) > 0 {
+			stored[operator] = operatorFields
+		}
+	}
+
+	if !app.encryptionEnabled() {
+		addUpdateFields(stored, "$set", bson.M{"data": data})
+		addUpdateFields(stored, "$unset", bson.M{"encrypted": ""})
+		return stored, nil
+	}
+	encrypted, err := app.encryptState(objectUID, namespace, data)
+	if err != nil {
+		return nil, err
+	}
+	addUpdateFields(stored, "$set", bson.M{"encrypted": encrypted})
+	addUpdateFields(stored, "$unset", bson.M{"data": ""})
+	return stored, nil
+}
+
+// historyContent is the encrypted part of history entry.
+type historyContent struct {
+	Snapshot bson.M          `bson:"snapshot,omitempty"`
+	Changes  []HistoryChange `bson:"changes,omitempty"`
+}
+
+func historyAdditionalData(entry *HistoryEntry) string {
+	return "history:" + entry.UID.Hex() + ":" + strconv.FormatInt(entry.Version, 10)
+}
+
+// sealHistoryEntry moves snapshot and changes of the history entry into its encrypted
+// content.
+func (app *BasicApp) sealHistoryEntry(owner bson.ObjectId, entry *HistoryEntry) error {
+	key, err := app.userDataKey(owner, true)
+	if err != nil {
+		return err
+	}
+	plaintext, err := bson.Marshal(historyContent{Snapshot: entry.Snapshot, Changes: entry.Changes})
+	if err != nil {
+		return err
+	}
+	entry.Encrypted, err = sealBytes(key, plaintext, historyAdditionalData(entry))
+	if err != nil {
+		return err
+	}
+	entry.Snapshot = nil
+	entry.Changes = nil
+	return nil
+}
+
+// openHistoryEntry decrypts snapshot and changes of the encrypted history entry.
+func (app *BasicApp) openHistoryEntry(owner bson.ObjectId, entry *HistoryEntry) error {
+	if entry.Encrypted == nil {
+		return nil
+	}
+	key, err := app.userDataKey(owner, false)
+	if err != nil {
+		return err
+	}
+	plaintext, err := openBytes(key, entry.Encrypted, historyAdditionalData(entry))
+	if err != nil {
+		return err
+	}
+	var content historyContent
+	err = bson.Unmarshal(plaintext, &content)
+	if err != nil {
+		return err
+	}
+	entry.Snapshot = content.Snapshot
+	entry.Changes = content.Changes
+	return nil
+}
+
+// EncryptStates encrypts user states and their history stored before the encryption was
+// enabled with `EncryptionKeyID` setting. States which change during the migration are
+// skipped, since every write encrypts them anyway.
+//
+// It returns number of encrypted states.
+func (app *BasicApp) EncryptStates() (int, error) {
+	if !app.encryptionEnabled() {
+		return 0, errNoEncryptionKey
+	}
+
+	iter := app.Coll.States.Find(bson.M{
+		"encrypted": bson.M{"$exists": false},
+		"data":      bson.M{"$exists": true},
+	}).Iter()
+
+	encrypted := 0
+	for {
+		var state State
+		if !iter.Next(&state) {
+			break
+		}
+		owner := stateOwner(&state)
+		err := app.encryptHistory(owner, state.ID)
+		if err != nil {
+			iter.Close()
+			return encrypted, err
+		}
+
+		data, err := app.encryptState(owner, state.Namespace, state.Data)
+		if err != nil {
+			iter.Close()
+			return encrypted, err
+		}
+		selector := bson.M{"_id": state.ID, "version": state.Version}
+		if state.Version == 0 {
+			// states stored before the versions were introduced have no version
+			selector["version"] = bson.M{"$in": []interface{}{nil, int64(0)}}
+		}
+		err = app.Coll.States.Update(selector, bson.M{
+			"$set":   bson.M{"encrypted": data},
+			"$unset": bson.M{"data": ""},
+		})
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			iter.Close()
+			return encrypted, err
+		}
+		encrypted++
+	}
+	return encrypted, iter.Close()
+}
+
+// encryptHistory encrypts history entries of the state which are not encrypted yet.
+func (app *BasicApp) encryptHistory(owner bson.ObjectId, stateID bson.ObjectId) error {
+	iter := app.Coll.History.Find(bson.M{
+		"uid":       stateID,
+		"encrypted": bson.M{"$exists": false},
+	}).Iter()
+
+	var entry HistoryEntry
+	for iter.Next(&entry) {
+		err := app.sealHistoryEntry(owner, &entry)
+		if err == nil {
+			err = app.Coll.History.UpdateId(entry.ID, bson.M{
+				"$set":   bson.M{"encrypted": entry.Encrypted},
+				"$unset": bson.M{"snapshot": "", "changes": ""},
+			})
+		}
+		if err != nil {
+			iter.Close()
+			return err
+		}
+		entry = HistoryEntry{}
+	}
+	return iter.Close()
+}
+
+// RewrapDataKeys wraps user data keys with the current master key given by
+// `EncryptionKeyID` setting. It's meant to be run after master key rotation, so the
+// previous master key can be removed from `EncryptionKeys` once it's done. Encrypted
+// user data doesn't change.
+//
+// It returns number of rewrapped data keys.
+func (app *BasicApp) RewrapDataKeys() (int, error) {
+	if !app.encryptionEnabled() {
+		return 0, errNoEncryptionKey
+	}
+	keyID := app.Settings.EncryptionKeyID
+
+	iter := app.Coll.DataKeys.Find(bson.M{"key_id": bson.M{"$ne": keyID}}).Iter()
+	rewrapped := 0
+	var dataKey DataKey
+	for iter.Next(&dataKey) {
+		masterKey := app.Settings.EncryptionKeys[dataKey.KeyID]
+		if masterKey == nil {
+			iter.Close()
+			return rewrapped, errors.New("Encryption key " + dataKey.KeyID + " not found in EncryptionKeys")
+		}
+		key, err := openBytes(masterKey, dataKey.Key, dataKeyAdditionalData(dataKey.UID))
+		if err != nil {
+			iter.Close()
+			return rewrapped, err
+		}
+		wrapped, err := sealBytes(app.Settings.EncryptionKeys[keyID], key, dataKeyAdditionalData(dataKey.UID))
+		if err != nil {
+			iter.Close()
+			return rewrapped, err
+		}
+		err = app.Coll.DataKeys.Update(bson.M{"_id": dataKey.UID, "key_id": dataKey.KeyID}, bson.M{
+			"$set": bson.M{"key_id": keyID, "key": wrapped},
+		})
+		if err != nil && err != mgo.ErrNotFound {
+			iter.Close()
+			return rewrapped, err
+		}
+		if err == nil {
+			rewrapped++
+		}
+		dataKey = DataKey{}
+	}
+	return rewrapped, iter.Close()
+}
@@ -0,0 +1,236 @@
+package basicserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+const (
+	defaultEventsBufferSize = 1024
+	eventsChannelSize       = 64
+	eventsHeartbeatInterval = 30 * time.Second
+)
+
+// Event is a notification about the user state or files change:
+//
+//    `ID` event id, which can be passed as `Last-Event-ID` to resume the stream
+//    `Type` "data" for state change, "file_upload" or "file_delete" for file changes,
+//      "reset" if the missed events can't be replayed and the state should be fetched again
+//    `Namespace` data namespace of the changed state
+//    `Keys` changed dotted paths of the user data
+//    `All` whether the whole user data was replaced
+//    `Version` state version after the change
+//    `File` name of the changed file
+//    `CreatedAt` time at which the change happened
+//
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Namespace string    `json:"namespace,omitempty"`
+	Keys      []string  `json:"keys,omitempty"`
+	All       bool      `json:"all,omitempty"`
+	Version   int64     `json:"version,omitempty"`
+	File      string    `json:"file,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	uid bson.ObjectId
+	seq uint64
+}
+
+// eventHub delivers events to the subscribed streams of the user. Recent events are kept
+// in a ring buffer, so reconnecting streams can be resumed. Events are delivered only
+// within the process.
+type eventHub struct {
+	mutex       sync.Mutex
+	epoch       string
+	seq         uint64
+	buffer      []Event
+	subscribers map[bson.ObjectId]map[chan Event]bool
+}
+
+func newEventHub(size int) *eventHub {
+	if size <= 0 {
+		size = defaultEventsBufferSize
+	}
+	return &eventHub{
+		// ids of the previous process are not resumed
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		buffer:      make([]Event, size),
+		subscribers: make(map[bson.ObjectId]map[chan Event]bool),
+	}
+}
+
+func (hub *eventHub) eventID(seq uint64) string {
+	return hub.epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// publish stores the event and delivers it to the user streams. Streams which don't keep
+// up are closed, so they can be resumed from the buffer.
+func (hub *eventHub) publish(objectUID bson.ObjectId, event Event) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	hub.seq++
+	event.uid = objectUID
+	event.seq = hub.seq
+	event.ID = hub.eventID(hub.seq)
+	event.CreatedAt = time.Now()
+	hub.buffer[hub.seq%uint64(len(hub.buffer))] = event
+
+	for events := range hub.subscribers[objectUID] {
+		select {
+		case events <- event:
+		default:
+			delete(hub.subscribers[objectUID], events)
+			close(events)
+		}
+	}
+}
+
+// subscribe returns channel of the user events along with the buffered events following
+// the last event id. If some of the events were dropped from the buffer already, a single
+// "reset" event is returned instead.
+func (hub *eventHub) subscribe(objectUID bson.ObjectId, lastEventID string) (chan Event, []Event) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	replay := []Event{}
+	if lastEventID != "" {
+		var oldest uint64 = 1
+		if size := uint64(len(hub.buffer)); hub.seq > size {
+			oldest = hub.seq - size + 1
+		}
+		seq, err := strconv.ParseUint(strings.TrimPrefix(lastEventID, hub.epoch+"-"), 10, 64)
+		if err != nil || !strings.HasPrefix(lastEventID, hub.epoch+"-") || seq > hub.seq || seq+1 < oldest {
+			replay = append(replay, Event{
+				ID:        hub.eventID(hub.seq),
+				Type:      "reset",
+				CreatedAt: time.Now(),
+			})
+		} else {
+			for s := seq + 1; s <= hub.seq; s++ {
+				if event := hub.buffer[s%uint64(len(hub.buffer))]; event.uid == objectUID {
+					replay = append(replay, event)
+				}
+			}
+		}
+	}
+
+	events := make(chan Event, eventsChannelSize)
+	if hub.subscribers[objectUID] == nil {
+		hub.subscribers[objectUID] = make(map[chan Event]bool)
+	}
+	hub.subscribers[objectUID][events] = true
+	return events, replay
+}
+
+// unsubscribe stops delivery of the user events to the channel.
+func (hub *eventHub) unsubscribe(objectUID bson.ObjectId, events chan Event) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	if hub.subscribers[objectUID][events] {
+		delete(hub.subscribers[objectUID], events)
+		close(events)
+	}
+	if len(hub.subscribers[objectUID]) == 0 {
+		delete(hub.subscribers, objectUID)
+	}
+}
+
+// publishStateEvent publishes "data" event of the Mongo update applied to the state, to
+// the event streams and webhooks.
+func (app *BasicApp) publishStateEvent(objectUID bson.ObjectId, namespace string, update bson.M, state *State) {
+	event := Event{
+		Type:      "data",
+		Namespace: namespaceName(namespace),
+		Version:   state.Version,
+	}
+	keys := make(map[string]bool)
+	for _, fields := range update {
+		fieldsMap, ok := fields.(bson.M)
+		if !ok {
+			continue
+		}
+		for field := range fieldsMap {
+			path, ok := splitDataPath(field)
+			if !ok {
+				continue
+			}
+			if len(path) == 0 {
+				event.All = true
+				continue
+			}
+			for i := range path {
+				path[i] = decodeKey(path[i])
+			}
+			keys[formatDataPath(path)] = true
+		}
+	}
+	if !event.All {
+		for key := range keys {
+			event.Keys = append(event.Keys, key)
+		}
+		sort.Strings(event.Keys)
+	}
+	app.events.publish(objectUID, event)
+	app.triggerWebhooks(objectUID, WebhookEvent{
+		Type:      event.Type,
+		Namespace: event.Namespace,
+		Keys:      event.Keys,
+		All:       event.All,
+		Version:   event.Version,
+	})
+}
+
+// publishFileEvent publishes "file_upload" or "file_delete" event, to the event streams
+// and webhooks.
+func (app *BasicApp) publishFileEvent(objectUID bson.ObjectId, eventType string, fileName string) {
+	app.events.publish(objectUID, Event{Type: eventType, File: fileName})
+	app.triggerWebhooks(objectUID, WebhookEvent{Type: eventType, File: fileName})
+}
+
+// lastEventID returns id of the last event received by reconnecting client.
+func lastEventID(ctx iris.Context) string {
+	if id := ctx.GetHeader("Last-Event-ID"); id != "" {
+		return id
+	}
+	return ctx.URLParam("last_event_id")
+}
+
+func writeServerSentEvent(w io.Writer, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+// TokenFromQuery is a middleware which passes `access_token` query parameter as
+// `Authorization` header to RequireAuth. It's meant for the resources which browsers
+// can't request with custom headers, such as EventSource and WebSocket. The parameter is
+// removed from the request URL, so it's not written to the request logs.
+func (app *BasicApp) TokenFromQuery() iris.Handler {
+	return func(ctx iris.Context) {
+		query := ctx.Request().URL.Query()
+		if token := query.Get("access_token"); token != "" {
+			if ctx.GetHeader("Authorization") == "" {
+				ctx.Request().Header.Set("Authorization", "Bearer "+token)
+			}
+			query.Del("access_token")
+			ctx.Request().URL.RawQuery = query.Encode()
+			ctx.Request().RequestURI = ctx.Request().URL.RequestURI()
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,77 @@
+package basicserver
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeEventsGet serves
+// Method:   GET
+// Resource: http://localhost/api/events
+//
+// This resource requires `Authorization` header or `access_token` query parameter, since
+// EventSource can't send custom headers, e.g.:
+//
+//		Authorization: Bearer {token}
+//
+// This is a Server-Sent Events stream of the user state and files changes. Each event
+// is sent with it's id and type, and the data is JSON encoded event:
+//
+//    id: 1k2j3h4g-12
+//    event: data
+//    data: {"id":"1k2j3h4g-12","type":"data","namespace":"default","keys":["profile.name"],"version":7,"created_at":"2019-03-19T10:00:00Z"}
+//
+// Event types are "data" for state changes, "file_upload" and "file_delete" for file
+// changes. Reconnecting clients send `Last-Event-ID` header (or `last_event_id` query
+// parameter) and receive the events they missed. If the events can't be replayed anymore,
+// "reset" event is sent, after which the state should be fetched again.
+//
+// Events are delivered by the server process in which the change happened.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeEventsGet() iris.Handler {
+	return func(ctx iris.Context) {
+		uid := ctx.Values().Get("uid").(string)
+		objectUID := bson.ObjectIdHex(uid)
+
+		events, replay := app.events.subscribe(objectUID, lastEventID(ctx))
+		defer app.events.unsubscribe(objectUID, events)
+
+		ctx.ContentType("text/event-stream")
+		ctx.Header("Cache-Control", "no-cache")
+		ctx.Header("X-Accel-Buffering", "no") // disables proxy buffering
+
+		writer := ctx.ResponseWriter()
+		for _, event := range replay {
+			if writeServerSentEvent(writer, event) != nil {
+				return
+			}
+		}
+		writer.Flush()
+
+		heartbeat := time.NewTicker(eventsHeartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-ctx.Request().Context().Done():
+				return
+			case event, ok := <-events:
+				if !ok { // the stream didn't keep up, the client resumes it
+					return
+				}
+				if writeServerSentEvent(writer, event) != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if _, err := writer.Write([]byte(": heartbeat\n\n")); err != nil {
+					return
+				}
+			}
+			writer.Flush()
+		}
+	}
+}
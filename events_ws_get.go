@@ -0,0 +1,124 @@
+package basicserver
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/gorilla/websocket"
+	"github.com/kataras/iris"
+)
+
+const eventsWriteTimeout = 10 * time.Second
+
+// checkOrigin allows WebSocket connections opened by the pages of the server itself or of
+// `AllowedOrigins`. Browsers send cookies and the query token of any page along with the
+// handshake, so other pages could use the connection on behalf of the user. Requests
+// without `Origin` header don't come from browsers and are allowed.
+func (app *BasicApp) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range app.Settings.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeEventsWebSocketGet serves
+// Method:   GET
+// Resource: ws://localhost/api/events/ws
+//
+// This resource requires `Authorization` header or `access_token` query parameter, since
+// browsers can't send custom headers with WebSocket handshake.
+//
+// This is a WebSocket equivalent of GET /api/events. Every event is sent as JSON text
+// message:
+//
+//    {
+//      "id": "1k2j3h4g-12",
+//      "type": "data",
+//      "namespace": "default",
+//      "keys": [ "profile.name" ],
+//      "version": 7,
+//      "created_at": "2019-03-19T10:00:00Z"
+//    }
+//
+// Reconnecting clients pass id of the last received event as `last_event_id` query
+// parameter and receive the events they missed, or "reset" event if they can't be replayed
+// anymore. Messages sent by the client are ignored.
+//
+// Connections are accepted only from the pages of the server itself or of `AllowedOrigins`
+// setting, other origins get status code `403`.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeEventsWebSocketGet() iris.Handler {
+	return func(ctx iris.Context) {
+		uid := ctx.Values().Get("uid").(string)
+		objectUID := bson.ObjectIdHex(uid)
+
+		upgrader := websocket.Upgrader{CheckOrigin: app.checkOrigin}
+		conn, err := upgrader.Upgrade(ctx.ResponseWriter(), ctx.Request(), nil)
+		if err != nil { // the upgrader responds with the error already
+			app.Iris.Logger().Debug(err)
+			return
+		}
+		defer conn.Close()
+
+		events, replay := app.events.subscribe(objectUID, lastEventID(ctx))
+		defer app.events.unsubscribe(objectUID, events)
+
+		// reading is needed to notice the closed connection
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		send := func(event Event) bool {
+			conn.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+			return conn.WriteJSON(event) == nil
+		}
+		for _, event := range replay {
+			if !send(event) {
+				return
+			}
+		}
+
+		heartbeat := time.NewTicker(eventsHeartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case event, ok := <-events:
+				if !ok || !send(event) {
+					return
+				}
+			case <-heartbeat.C:
+				deadline := time.Now().Add(eventsWriteTimeout)
+				if conn.WriteControl(websocket.PingMessage, nil, deadline) != nil {
+					return
+				}
+			}
+		}
+	}
+}
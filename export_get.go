@@ -0,0 +1,93 @@
+package basicserver
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeExportGet serves
+// Method:   GET
+// Resource: http://localhost/api/export
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Authorization: Bearer {token}
+//
+// If everything goes well, then this will return status code `200` and `application/json`
+// response with the data of all the user namespaces and records of all the user collections:
+//
+//    {
+//      "data": {
+//        "default": {
+//          "foo": "bar"
+//        },
+//        "notes": {
+//          "first": "hello"
+//        }
+//      },
+//      "collections": {
+//        "todos": [
+//          {
+//            "id": "5c90a7cde3fd3c4e4c7d4b5f",
+//            "data": {
+//              "title": "Buy milk"
+//            },
+//            "created_at": "2019-03-19T10:00:00Z",
+//            "updated_at": "2019-03-19T10:00:00Z"
+//          }
+//        ]
+//      }
+//    }
+//
+// In case of error, this will return status code `500` and `text/plain` error
+// message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeExportGet() iris.Handler {
+	return func(ctx iris.Context) {
+		uid := ctx.Values().Get("uid").(string)
+		objectUID := bson.ObjectIdHex(uid)
+
+		data := iris.Map{}
+		var state State
+		iter := app.Coll.States.Find(userStatesSelector(objectUID)).Iter()
+		for iter.Next(&state) {
+			err := app.openState(&state, nil)
+			if err != nil {
+				iter.Close()
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+				return
+			}
+			if state.Data == nil {
+				state.Data = bson.M{}
+			}
+			data[namespaceName(state.Namespace)] = decodeKeys(state.Data)
+			state = State{}
+		}
+		err := iter.Close()
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		collections := map[string][]Record{}
+		var record Record
+		iter = app.Coll.Records.Find(bson.M{"uid": objectUID}).Sort("collection", "_id").Iter()
+		for iter.Next(&record) {
+			collections[record.Collection] = append(collections[record.Collection], record.decoded())
+			record = Record{}
+		}
+		err = iter.Close()
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		ctx.JSON(iris.Map{
+			"data":        data,
+			"collections": collections,
+		})
+	}
+}
@@ -48,10 +48,56 @@ func (app *BasicApp) ServeFileDelete() iris.Handler {
 			return
 		}
 
-		err = app.Coll.Files.Remove(uid + ":" + filename)
+		err = app.removeFile(bson.ObjectIdHex(uid), filename)
 		if err != nil {
 			app.HandleError(err, ctx, iris.StatusBadRequest)
 			return
 		}
 	}
 }
+
+// removeFile removes the user file along with the links to it. Removal of the file which
+// doesn't exist is not an error.
+func (app *BasicApp) removeFile(objectUID bson.ObjectId, name string) error {
+	return app.removeStoredUserFile(objectUID, name, objectUID.Hex()+":"+name)
+}
+
+// removeStoredUserFile removes the user file with given name, which is stored under given
+// file name. It's different from the user file name if the file was moved aside, such as
+// the file deleted by the batch.
+func (app *BasicApp) removeStoredUserFile(objectUID bson.ObjectId, name string, fileName string) error {
+	var size int64
+	file, err := app.openFile(fileName)
+	found := err == nil
+	if found {
+		size = file.Length
+	}
+
+	err = app.removeStoredFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		err = app.removeThumbnails(objectUID.Hex() + ":" + name)
+		if err != nil {
+			app.Iris.Logger().Error(err)
+		}
+		err = app.trackFileUsage(objectUID, -size, -1)
+		if err != nil { // the file itself is removed
+			app.Iris.Logger().Error(err)
+		}
+		err = app.recordFileSync(objectUID, name, true)
+		if err != nil {
+			app.Iris.Logger().Error(err)
+		}
+		app.publishFileEvent(objectUID, "file_delete", name)
+	}
+
+	// links to removed file are not valid anymore
+	_, err = app.Coll.Links.RemoveAll(bson.M{
+		"uid":  objectUID,
+		"file": name,
+	})
+	return err
+}
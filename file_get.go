@@ -1,13 +1,11 @@
 package basicserver
 
 import (
-	"time"
-
 	"github.com/kataras/iris"
 )
 
 // ServeFileGet serves
-// Method:   GET
+// Method:   GET, HEAD
 // Resource: http://localhost/api/file/{id:string}
 //
 // This resource requires `Authorization` header, e.g.:
@@ -15,10 +13,37 @@ import (
 //		Authorization: Bearer {token}
 //
 // If everything goes well then we will receive status code 200 and response with the file
-// associated with provided id in query parameter.
+// associated with provided id in query parameter. `Content-Type` header is the stored
+// content type of the file and `Content-Disposition` header holds it's name, e.g.:
+//
+//    Content-Type: image/jpeg
+//    Content-Disposition: inline; filename=golang.jpg
+//
+// Responses can be cached by browsers and proxies. `ETag` is the checksum of the file and
+// `Last-Modified` is it's upload time, so the overwritten file is downloaded again.
+// `Cache-Control` is taken from `FileCacheControl` setting by the content type of the file:
+//
+//    ETag: "1b8e8e5a4c4f8d8d4a3e8c0b8c5d6e7f"
+//    Last-Modified: Tue, 19 Mar 2019 10:00:00 GMT
+//    Cache-Control: private, no-cache
+//
+// If the file matches `If-None-Match` or `If-Modified-Since` header of the request, this
+// will return status code `304` and no response body. `Range` requests are supported.
+// `HEAD` request returns the same headers, including `Content-Length`, without the content.
+//
+// JPEG, PNG and GIF images can be resized with following optional parameters:
+//
+//    `w`, `h` width and height of the image, one of `ImageSizes` setting values
+//      (32, 64, 128, 256, 512 or 1024 by default)
+//    `fit` "contain" (default) scales the image to fit the size without enlarging it,
+//      "cover" scales and crops it to fill the size, "fill" stretches it to the size
+//    `format` "jpeg", "png" or "gif", the format of the original by default
 //
-// In case of error, this will return status code `400` or `500` and `text/plain` error
-// message as a response.
+// e.g. /api/file/golang.jpg?w=128&h=128&fit=cover. Resized variants are cached until the
+// original is overwritten or removed.
+//
+// In case of error, this will return status code `400`, `413` (too large image), `415`
+// (resizing file other than image) or `500` and `text/plain` error message as a response.
 //
 // In case of invalid/expired token, this will return status code `401` and `text/plain`
 // error message as a response.
@@ -29,7 +54,14 @@ func (app *BasicApp) ServeFileGet() iris.Handler {
 		uid := ctx.Values().Get("uid").(string)
 		fileName := uid + ":" + fileID
 
-		file, err := app.Coll.Files.Open(fileName)
+		variant, err := app.imageVariantFrom(ctx)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		file, err := app.openFile(fileName)
 		if err != nil {
 			if err.Error() == "not found" {
 				app.HandleError(err, ctx, iris.StatusUnauthorized)
@@ -41,6 +73,10 @@ func (app *BasicApp) ServeFileGet() iris.Handler {
 		}
 		defer file.Close()
 
-		ctx.ServeContent(file, fileName, time.Now(), true)
+		if variant != nil {
+			app.serveThumbnail(ctx, file, uid, fileID, variant)
+			return
+		}
+		app.serveFile(ctx, file, fileID)
 	}
 }
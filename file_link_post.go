@@ -0,0 +1,120 @@
+package basicserver
+
+import (
+	"errors"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const defaultLinkExpiration = 24 * time.Hour
+
+type fileLinkInput struct {
+	Expires   int64  `json:"expires"`
+	Downloads int    `json:"downloads"`
+	Password  string `json:"password"`
+}
+
+// ServeFileLinkPost serves
+// Method:   POST
+// Resource: http://localhost/api/file/{id:string}/link
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//    Content-Type: application/json
+//    Authorization: Bearer {token}
+//
+// Sample request to be `POST`ed to the /api/file/{id:string}/link resource as `application/json`.
+// All the fields are optional. `expires` is number of seconds for which the link is valid
+// (defaults to 24 hours), `downloads` limits number of downloads and `password` protects
+// the link with a password:
+//
+//    {
+//      "expires": 3600,
+//      "downloads": 5,
+//      "password": "linkPassword"
+//    }
+//
+// If everything goes well, then this will return status code `200` and `application/json`
+// response with the link which can be accessed without `Authorization` header:
+//
+//    {
+//      "id": "5c0a7922c9e77c0008d7b5a0",
+//      "url": "http://localhost/link/5c0a7922c9e77c0008d7b5a0?expires=1543567182&signature=...",
+//      "expires": 1543567182
+//    }
+//
+// In case of error, this will return status code `400`, `404` or `500` and `text/plain` error
+// message (e.g. "No Such File") as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeFileLinkPost() iris.Handler {
+	return func(ctx iris.Context) {
+		var input fileLinkInput
+		if ctx.Request().ContentLength != 0 {
+			err := ctx.ReadJSON(&input)
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusBadRequest)
+				return
+			}
+		}
+		if input.Expires < 0 || input.Downloads < 0 {
+			err := errors.New("Incorrect Link Options")
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString("Incorrect Link Options")
+			return
+		}
+
+		fileID := ctx.Params().Get("id")
+		uid := ctx.Values().Get("uid").(string)
+
+		_, err := app.openFile(uid + ":" + fileID)
+		if err != nil {
+			if err.Error() == "not found" {
+				app.HandleError(err, ctx, iris.StatusNotFound)
+				ctx.WriteString("No Such File")
+			} else {
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+			}
+			return
+		}
+
+		expiration := defaultLinkExpiration
+		if input.Expires > 0 {
+			expiration = time.Duration(input.Expires) * time.Second
+		}
+		timeNow := time.Now()
+		link := Link{
+			ID:           bson.NewObjectId(),
+			UID:          bson.ObjectIdHex(uid),
+			File:         fileID,
+			MaxDownloads: input.Downloads,
+			ExpiresAt:    timeNow.Add(expiration),
+			CreatedAt:    timeNow,
+		}
+		if input.Password != "" {
+			passEnc, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+				return
+			}
+			link.Password = string(passEnc)
+		}
+
+		err = app.Coll.Links.Insert(link)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		ctx.JSON(iris.Map{
+			"id":      link.ID.Hex(),
+			"url":     app.absoluteURL(ctx, link.Path(app.Settings.Secret)),
+			"expires": link.ExpiresAt.Unix(),
+		})
+	}
+}
@@ -1,11 +1,21 @@
 package basicserver
 
 import (
+	"bufio"
+	"encoding/json"
 	"io"
+	"mime/multipart"
+	"net/http"
+	"sort"
 
+	"github.com/globalsign/mgo/bson"
 	"github.com/kataras/iris"
 )
 
+// maxUploadMemory is the part of the multipart form kept in memory, the rest is stored in
+// temporary files.
+const maxUploadMemory = 32 << 20
+
 // ServeFilePost serves
 // Method:   POST
 // Resource: http://localhost/api/file
@@ -15,43 +25,280 @@ import (
 //    Content-Type: multipart/form-data
 //    Authorization: Bearer {token}
 //
-// In order to store a file, a POST request to /api/file resource need to be send as
-// `multipart/form-data`. Only one file is accepted per request. Field name of uploaded
-// file should be "file" and it’s filename will be it’s id. Resubmitted files
+// In order to store files, a POST request to /api/file resource need to be send as
+// `multipart/form-data`. All the file parts of the form are stored, usually sent as
+// "file" fields, and filename of each file will be it’s id. Resubmitted files
 // (files with the same filenames) are overwritten.
 //
-// If everything goes well, then this will return status code `200` and no response body.
+// Content type of the file is taken from the `Content-Type` header of it's part. If it's
+// missing or generic ("application/octet-stream"), the type is detected from the file
+// content and extension. Both the declared and detected types are kept.
+//
+// Custom metadata can be sent as JSON object in "metadata" field, which applies to all the
+// files, or in "metadata[{filename}]" field for the single file, e.g.:
+//
+//    metadata[avatar.jpg]: {"width": 128, "height": 128}
+//
+// Metadata is returned by GET /api/files and replaced when the file is overwritten.
+//
+// Files are checked against `UploadPolicy` setting, or the policy of the route set with
+// `WithUploadPolicy`. The request body larger than allowed fails right away with status code
+// `413` and "Upload Too Large". Files of not allowed types or extensions fail with
+// "File Type Not Allowed" or "File Extension Not Allowed", and files breaking the name rules
+// with "File Name Not Allowed".
 //
-// In case of error, this will return status code `400` or `500` and `text/plain` error
-// message as response.
+// Uploaded files count towards user quota. If the file is larger than allowed single
+// file size, it fails with "File Too Large". If the file would exceed total size or number
+// of user files, it fails with "File Quota Exceeded".
+//
+// If at least one file is stored, then this will return status code `200` and
+// `application/json` response with result of each file:
+//
+//    {
+//      "files": [
+//        { "id": "avatar.jpg", "size": 5793, "content_type": "image/jpeg" },
+//        { "id": "video.mp4", "error": "File Too Large" }
+//      ]
+//    }
+//
+// If no file is stored, this will return status code `400`, `413`, `415`, `500` or `507` and
+// `text/plain` error message of the first file as response. Malformed metadata fails the
+// whole request with status code `400`.
 //
 // In case of invalid/expired token, this will return status code `401` and `text/plain`
 // error message as a response.
 //
 func (app *BasicApp) ServeFilePost() iris.Handler {
 	return func(ctx iris.Context) {
-		file, info, err := ctx.FormFile("file")
+		policy := app.uploadPolicy(ctx)
+		body, err := policy.limitBody(ctx)
 		if err != nil {
-			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			app.handleFileError(err, ctx)
+			return
+		}
+		err = ctx.Request().ParseMultipartForm(maxUploadMemory)
+		if body.exceeded {
+			app.handleFileError(errUploadTooLarge, ctx)
 			return
 		}
-		defer file.Close()
-
-		uid := ctx.Values().Get("uid").(string)
-		fileName := uid + ":" + info.Filename
-		_ = app.Coll.Files.Remove(fileName)
-		newFile, err := app.Coll.Files.Create(fileName)
 		if err != nil {
 			app.HandleError(err, ctx, iris.StatusInternalServerError)
 			return
 		}
-		defer newFile.Close()
+		form := ctx.Request().MultipartForm
+		defer form.RemoveAll()
 
-		fileReader := io.Reader(file)
-		_, err = io.Copy(newFile, fileReader)
-		if err != nil {
-			app.HandleError(err, ctx, iris.StatusInternalServerError)
+		parts := uploadedParts(form)
+		if len(parts) == 0 {
+			app.HandleError(errNoFileProvided, ctx, iris.StatusBadRequest)
+			ctx.WriteString(errNoFileProvided.Error())
+			return
+		}
+		metadata := make([]map[string]interface{}, len(parts))
+		for i, part := range parts {
+			metadata[i], err = uploadMetadata(form, part.Filename)
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusBadRequest)
+				ctx.WriteString(err.Error())
+				return
+			}
+		}
+
+		objectUID := bson.ObjectIdHex(ctx.Values().Get("uid").(string))
+
+		var firstErr error
+		stored := 0
+		results := make([]fileResult, len(parts))
+		for i, part := range parts {
+			results[i].ID = part.Filename
+			contentType, err := app.storeUploadedPart(objectUID, part, metadata[i], policy)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				results[i].Error = err.Error()
+				continue
+			}
+			results[i].Size = part.Size
+			results[i].ContentType = contentType
+			stored++
+		}
+		if stored == 0 {
+			app.handleFileError(firstErr, ctx)
+			return
+		}
+		ctx.JSON(iris.Map{"files": results})
+	}
+}
+
+// fileResult is the result of single uploaded file.
+type fileResult struct {
+	ID          string `json:"id"`
+	Size        int64  `json:"size,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// uploadedParts returns file parts of the form ordered by field name, keeping the order
+// of the files within the field.
+func uploadedParts(form *multipart.Form) []*multipart.FileHeader {
+	fields := make([]string, 0, len(form.File))
+	for field := range form.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var parts []*multipart.FileHeader
+	for _, field := range fields {
+		parts = append(parts, form.File[field]...)
+	}
+	return parts
+}
+
+// uploadMetadata returns custom metadata of the uploaded file. Metadata of the file itself
+// is preferred over the metadata of all the files.
+func uploadMetadata(form *multipart.Form, name string) (map[string]interface{}, error) {
+	values := form.Value["metadata["+name+"]"]
+	if len(values) == 0 {
+		values = form.Value["metadata"]
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	return parseFileMetadata(values[0])
+}
+
+func (app *BasicApp) storeUploadedPart(objectUID bson.ObjectId, part *multipart.FileHeader, metadata map[string]interface{}, policy *UploadPolicy) (string, error) {
+	file, err := part.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return app.storeFile(objectUID, fileUpload{
+		name:        part.Filename,
+		size:        part.Size,
+		reader:      file,
+		contentType: part.Header.Get("Content-Type"),
+		metadata:    metadata,
+		policy:      policy,
+	})
+}
+
+// fileUpload is the user file to be stored along with it's declared content type, custom
+// metadata and the upload policy it's checked against. Usage which is reserved for the file
+// already, such as by the resumable upload, is taken over by storeFile.
+type fileUpload struct {
+	name          string
+	size          int64
+	reader        io.Reader
+	contentType   string
+	metadata      map[string]interface{}
+	policy        *UploadPolicy
+	reservedBytes int64
+	reservedCount int
+}
+
+// storeFile stores the user file of given size, overwriting the previous one with the same
+// name. The file is checked against the upload policy and the user quota first, with it's
+// size reserved until the file is stored. Usage reserved by the caller is released if the
+// file isn't stored. Stored content type of the file is returned.
+func (app *BasicApp) storeFile(objectUID bson.ObjectId, upload fileUpload) (string, error) {
+	fileName := objectUID.Hex() + ":" + upload.name
+
+	// usage which has to be released if the file isn't stored
+	reservedBytes, reservedCount := upload.reservedBytes, upload.reservedCount
+	defer func() {
+		if reservedBytes == 0 && reservedCount == 0 {
 			return
 		}
+		err := app.trackFileUsage(objectUID, -reservedBytes, -reservedCount)
+		if err != nil {
+			app.Iris.Logger().Error(err)
+		}
+	}()
+
+	err := upload.policy.checkName(upload.name)
+	if err == nil {
+		err = upload.policy.checkSize(upload.size)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var overwritten int64
+	oldFile, err := app.openFile(fileName)
+	overwrite := err == nil
+	if overwrite {
+		overwritten = oldFile.Length
+	}
+	bytes, count := upload.size-overwritten, 1
+	if overwrite {
+		count = 0
+	}
+	err = app.reserveFileQuota(objectUID, upload.size, bytes-reservedBytes, count-reservedCount)
+	if err != nil {
+		return "", err
+	}
+	reservedBytes, reservedCount = bytes, count
+
+	reader := bufio.NewReaderSize(upload.reader, sniffLength)
+	head, err := reader.Peek(sniffLength)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", err
+	}
+	detected := http.DetectContentType(head)
+	contentType := fileContentType(upload.name, upload.contentType, detected)
+	// declared type is checked along with the type of the actual content
+	err = upload.policy.checkTypes(policyContentType(upload.name, detected), contentType)
+	if err != nil {
+		return "", err
+	}
+
+	_ = app.removeStoredFile(fileName)
+	// the previous file is gone, so the new file is reserved as a whole
+	reservedBytes, reservedCount = upload.size, 1
+	meta := fileMetadata{DeclaredContentType: upload.contentType, DetectedContentType: detected}
+	if upload.metadata != nil {
+		meta.Data = encodeKeys(upload.metadata)
+	}
+	newFile := &storedFile{Filename: fileName, ContentType: contentType, Metadata: meta}
+	err = app.createFile(newFile, reader, upload.size)
+	if err != nil {
+		return "", err
+	}
+	// the file itself is stored, the reservation is corrected if it's size differs
+	reservedBytes, reservedCount = upload.size-newFile.Length, 0
+
+	if overwrite {
+		err = app.removeThumbnails(fileName)
+		if err != nil {
+			app.Iris.Logger().Error(err)
+		}
+	}
+
+	err = app.recordFileSync(objectUID, upload.name, false)
+	if err != nil {
+		app.Iris.Logger().Error(err)
+	}
+	app.publishFileEvent(objectUID, "file_upload", upload.name)
+	return contentType, nil
+}
+
+// parseFileMetadata parses custom metadata of the file, which has to be JSON object
+// following the same rules as the user data.
+func parseFileMetadata(value string) (map[string]interface{}, error) {
+	if len(value) > maxFileMetadataSize {
+		return nil, errMetadataTooLarge
+	}
+	var metadata map[string]interface{}
+	err := json.Unmarshal([]byte(value), &metadata)
+	if err != nil || metadata == nil {
+		return nil, errInvalidMetadata
+	}
+	err = validateDataValue(metadata, 0)
+	if err != nil {
+		return nil, err
 	}
+	return metadata, nil
 }
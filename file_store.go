@@ -0,0 +1,367 @@
+package basicserver
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// fileChunkSize is the size of GridFS chunks, the same as used by mgo.
+const fileChunkSize = 255 * 1024
+
+var (
+	errUnsupportedFileStorage = errors.New("Unsupported File Storage")
+	errFileStorageDirMissing  = errors.New("File Storage Dir Missing")
+)
+
+// FileStore keeps content of the stored files by their ids. Files are described in
+// "files.files" collection the same as GridFS files, whatever the store, so they're listed,
+// renamed and counted with Mongo queries. Only the content is kept by the store.
+type FileStore interface {
+	// Put stores the content read from the reader, replacing the content with the same id.
+	// Size is -1 if it's not known in advance.
+	Put(id bson.ObjectId, reader io.Reader, size int64) error
+	// Get returns the content of given size.
+	Get(id bson.ObjectId, size int64) (FileContent, error)
+	// Delete removes the content. Removal of the content which doesn't exist is not an error.
+	Delete(id bson.ObjectId) error
+}
+
+// FileContent is the stored content, which can be read from any offset.
+type FileContent interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// FileStorageSettings choose the store of the file contents:
+//
+//    `Backend` "gridfs" (default), "disk" or "s3"
+//    `Dir` directory of the "disk" store
+//    `S3` bucket of the "s3" store
+//
+type FileStorageSettings struct {
+	Backend string
+	Dir     string
+	S3      S3Settings
+}
+
+// NewFileStore returns the store chosen by the settings.
+func NewFileStore(db *mgo.Database, settings FileStorageSettings) (FileStore, error) {
+	switch settings.Backend {
+	case "", "gridfs":
+		return NewGridFSStore(db.GridFS(filesCollection)), nil
+	case "disk":
+		return NewDiskStore(settings.Dir)
+	case "s3":
+		return NewS3Store(settings.S3)
+	}
+	return nil, errUnsupportedFileStorage
+}
+
+// MigrateFiles copies content of all the stored files from one store to another, e.g. from
+// GridFS to S3 before `FileStorage` setting is changed. Files are described by the same
+// documents whatever the store, so only the content is copied. It's kept in the source store
+// as well. Files stored meanwhile are not copied, so the server should be stopped. Number of
+// copied files is returned.
+func MigrateFiles(db *mgo.Database, from FileStore, to FileStore) (int, error) {
+	return migrateFiles(db, nil, from, to)
+}
+
+// migrateFiles copies content of the stored files matching the selector.
+func migrateFiles(db *mgo.Database, selector bson.M, from FileStore, to FileStore) (int, error) {
+	copied := 0
+	var file storedFile
+	iter := db.GridFS(filesCollection).Files.Find(selector).Select(bson.M{"filename": 1, "length": 1}).Iter()
+	for iter.Next(&file) {
+		content, err := from.Get(file.ID, file.Length)
+		if err == nil {
+			err = to.Put(file.ID, content, file.Length)
+			content.Close()
+		}
+		if err != nil {
+			iter.Close()
+			return copied, fmt.Errorf("%s: %v", file.Filename, err)
+		}
+		copied++
+	}
+	return copied, iter.Close()
+}
+
+// storedFile is GridFS document describing the stored file. It's content is got from the
+// store once the file is read.
+type storedFile struct {
+	ID          bson.ObjectId `bson:"_id"`
+	Filename    string        `bson:"filename"`
+	Length      int64         `bson:"length"`
+	ChunkSize   int           `bson:"chunkSize"`
+	UploadDate  time.Time     `bson:"uploadDate"`
+	MD5         string        `bson:"md5"`
+	ContentType string        `bson:"contentType,omitempty"`
+	Metadata    interface{}   `bson:"metadata,omitempty"`
+
+	store   FileStore
+	content FileContent
+}
+
+func (file *storedFile) open() error {
+	if file.content != nil {
+		return nil
+	}
+	content, err := file.store.Get(file.ID, file.Length)
+	if err != nil {
+		return err
+	}
+	file.content = content
+	return nil
+}
+
+func (file *storedFile) Read(p []byte) (int, error) {
+	err := file.open()
+	if err != nil {
+		return 0, err
+	}
+	return file.content.Read(p)
+}
+
+func (file *storedFile) Seek(offset int64, whence int) (int64, error) {
+	err := file.open()
+	if err != nil {
+		return 0, err
+	}
+	return file.content.Seek(offset, whence)
+}
+
+// Close closes the content, if it was read.
+func (file *storedFile) Close() error {
+	if file.content == nil {
+		return nil
+	}
+	err := file.content.Close()
+	file.content = nil
+	return err
+}
+
+// countingReader counts the bytes read, and keeps the read error apart from errors of the
+// store the content is written to.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+	err    error
+}
+
+func (reader *countingReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	reader.count += int64(n)
+	if err != nil && err != io.EOF {
+		reader.err = err
+	}
+	return n, err
+}
+
+// createFile stores the content read from the reader as the file described by the given
+// document, which is inserted once the content is stored. So a file with the same name
+// fails only then, with duplicate key error.
+func (app *BasicApp) createFile(file *storedFile, reader io.Reader, size int64) error {
+	if file.ID == "" {
+		file.ID = bson.NewObjectId()
+	}
+	checksum := md5.New()
+	counter := &countingReader{reader: io.TeeReader(reader, checksum)}
+	err := app.Storage.Put(file.ID, counter, size)
+	if counter.err != nil {
+		err = counter.err
+	}
+	if err != nil {
+		app.Storage.Delete(file.ID)
+		return err
+	}
+
+	file.Length = counter.count
+	file.ChunkSize = fileChunkSize
+	file.UploadDate = time.Now()
+	file.MD5 = hex.EncodeToString(checksum.Sum(nil))
+	err = app.Coll.Files.Files.Insert(file)
+	if err != nil {
+		app.Storage.Delete(file.ID)
+		return err
+	}
+	file.store = app.Storage
+	return nil
+}
+
+// openFile returns the file with given name, or mgo.ErrNotFound.
+func (app *BasicApp) openFile(name string) (*storedFile, error) {
+	return app.findFile(bson.M{"filename": name})
+}
+
+// openFileId returns the file with given id, or mgo.ErrNotFound.
+func (app *BasicApp) openFileId(id bson.ObjectId) (*storedFile, error) {
+	return app.findFile(bson.M{"_id": id})
+}
+
+func (app *BasicApp) findFile(selector bson.M) (*storedFile, error) {
+	var file storedFile
+	err := app.Coll.Files.Files.Find(selector).One(&file)
+	if err != nil {
+		return nil, err
+	}
+	file.store = app.Storage
+	return &file, nil
+}
+
+// removeStoredFile removes the file with given name along with it's content. Removal of the
+// file which doesn't exist is not an error.
+func (app *BasicApp) removeStoredFile(name string) error {
+	return app.removeStoredFiles(bson.M{"filename": name})
+}
+
+// removeStoredFiles removes all the files matching the selector.
+func (app *BasicApp) removeStoredFiles(selector bson.M) error {
+	var item fileItem
+	iter := app.Coll.Files.Files.Find(selector).Select(bson.M{"_id": 1}).Iter()
+	for iter.Next(&item) {
+		err := app.removeStoredFileId(item.ID)
+		if err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
+
+// removeStoredFileId removes the document of the file first, so the file is gone even if
+// it's content fails to be removed.
+func (app *BasicApp) removeStoredFileId(id bson.ObjectId) error {
+	err := app.Coll.Files.Files.RemoveId(id)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	return app.Storage.Delete(id)
+}
+
+// gridFSStore keeps the content in chunks of GridFS, so files stored before the stores were
+// pluggable are served as they are.
+type gridFSStore struct {
+	gfs *mgo.GridFS
+}
+
+// NewGridFSStore returns store keeping the content in chunks collection of the GridFS.
+func NewGridFSStore(gfs *mgo.GridFS) FileStore {
+	gfs.Chunks.EnsureIndex(mgo.Index{
+		Key:        []string{"files_id", "n"},
+		Unique:     true,
+		Background: true,
+	})
+	return &gridFSStore{gfs: gfs}
+}
+
+func (store *gridFSStore) Put(id bson.ObjectId, reader io.Reader, size int64) error {
+	err := store.Delete(id)
+	if err != nil {
+		return err
+	}
+	buffer := make([]byte, fileChunkSize)
+	for n := 0; ; n++ {
+		read, readErr := io.ReadFull(reader, buffer)
+		if read > 0 {
+			err = store.gfs.Chunks.Insert(bson.M{
+				"_id":      bson.NewObjectId(),
+				"files_id": id,
+				"n":        n,
+				"data":     buffer[:read],
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// Get reads the chunks with mgo, which needs the document of the file to be stored already.
+func (store *gridFSStore) Get(id bson.ObjectId, size int64) (FileContent, error) {
+	return store.gfs.OpenId(id)
+}
+
+func (store *gridFSStore) Delete(id bson.ObjectId) error {
+	_, err := store.gfs.Chunks.RemoveAll(bson.M{"files_id": id})
+	return err
+}
+
+// diskStore keeps the content in files of the directory, named after their ids. Files are
+// spread over subdirectories by the last two characters of the id.
+type diskStore struct {
+	dir string
+}
+
+// NewDiskStore returns store keeping the content in the directory, which is created if it
+// doesn't exist.
+func NewDiskStore(dir string) (FileStore, error) {
+	if dir == "" {
+		return nil, errFileStorageDirMissing
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &diskStore{dir: dir}, nil
+}
+
+func (store *diskStore) path(id bson.ObjectId) string {
+	name := id.Hex()
+	return filepath.Join(store.dir, name[len(name)-2:], name)
+}
+
+// Put writes the content to temporary file, which is renamed once it's complete.
+func (store *diskStore) Put(id bson.ObjectId, reader io.Reader, size int64) error {
+	path := store.path(id)
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(path), id.Hex()+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(temp, reader)
+	if err == nil {
+		err = temp.Sync()
+	}
+	closeErr := temp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
+
+func (store *diskStore) Get(id bson.ObjectId, size int64) (FileContent, error) {
+	return os.Open(store.path(id))
+}
+
+func (store *diskStore) Delete(id bson.ObjectId) error {
+	err := os.Remove(store.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,273 @@
+package basicserver
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+const (
+	defaultFilesLimit   = 50
+	maxFilesLimit       = 1000
+	maxFileMetadataSize = 16 * 1024
+	// defaultFileCacheControl lets browsers keep user files, but they have to revalidate them.
+	defaultFileCacheControl = "private, no-cache"
+	// sniffLength is the number of bytes used to detect content type of the file.
+	sniffLength = 512
+)
+
+var (
+	errNoFileProvided   = errors.New("No File Provided")
+	errInvalidMetadata  = errors.New("Invalid Metadata")
+	errMetadataTooLarge = errors.New("Metadata Too Large")
+)
+
+// fileSortFields maps sort keys of the file listing to GridFS fields.
+var fileSortFields = map[string]string{
+	"name":        "filename",
+	"size":        "length",
+	"uploaded_at": "uploadDate",
+}
+
+// fileInfo describes stored user file. Name of the file is it's id, the same as in /api/file/{id}.
+type fileInfo struct {
+	Name        string      `json:"id"`
+	Size        int64       `json:"size"`
+	ContentType string      `json:"content_type"`
+	UploadedAt  time.Time   `json:"uploaded_at"`
+	MD5         string      `json:"md5"`
+	Metadata    interface{} `json:"metadata,omitempty"`
+}
+
+// fileMetadata is stored as GridFS metadata of the user file. `Data` holds the custom
+// metadata with keys escaped the same as the user data.
+type fileMetadata struct {
+	DeclaredContentType string      `bson:"declared_content_type,omitempty"`
+	DetectedContentType string      `bson:"detected_content_type,omitempty"`
+	Data                interface{} `bson:"data,omitempty"`
+}
+
+// fileContentType returns content type of the file to be stored. Declared type is preferred,
+// unless it's missing, malformed or generic. Generic detected types are refined by
+// extension of the file name, e.g. for CSS or JSON files detected as plain text.
+func fileContentType(name string, declared string, detected string) string {
+	if mediaType, _, err := mime.ParseMediaType(declared); err == nil && mediaType != "application/octet-stream" {
+		return declared
+	}
+	if detected == "application/octet-stream" || strings.HasPrefix(detected, "text/plain") {
+		if byExtension := mime.TypeByExtension(filepath.Ext(name)); byExtension != "" {
+			return byExtension
+		}
+	}
+	return detected
+}
+
+// serveFile writes the user file with it's stored content type. Files are validated by
+// their checksum and upload date, so overwritten files are never served from cache.
+func (app *BasicApp) serveFile(ctx iris.Context, file *storedFile, name string) {
+	contentType := file.ContentType
+	if contentType == "" { // files stored before content types were kept
+		contentType = mime.TypeByExtension(filepath.Ext(name))
+	}
+	app.serveContent(ctx, file, name, contentType, file.MD5, file.UploadDate)
+}
+
+// serveContent writes the file content inline, with it's name in `Content-Disposition`
+// header. Conditional and range requests are supported, with strong `ETag` of the content
+// checksum and `Last-Modified` time.
+func (app *BasicApp) serveContent(ctx iris.Context, content io.ReadSeeker, name string, contentType string, md5 string, modified time.Time) {
+	if contentType != "" {
+		ctx.Header("Content-Type", contentType)
+	}
+	ctx.Header("Content-Disposition", mime.FormatMediaType("inline", map[string]string{
+		"filename": filepath.Base(name),
+	}))
+	ctx.Header("Cache-Control", app.fileCacheControl(contentType))
+	if md5 != "" {
+		ctx.Header("ETag", `"`+md5+`"`)
+	}
+	http.ServeContent(ctx.ResponseWriter(), ctx.Request(), name, modified, content)
+}
+
+// fileCacheControl returns `Cache-Control` header of the file with given content type. The
+// most specific pattern of `FileCacheControl` setting is used, e.g. "image/png" over
+// "image/*" over "*/*".
+func (app *BasicApp) fileCacheControl(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	patterns := []string{mediaType, strings.SplitN(mediaType, "/", 2)[0] + "/*", "*/*"}
+	for _, pattern := range patterns {
+		if value, ok := app.Settings.FileCacheControl[pattern]; ok {
+			return value
+		}
+	}
+	return defaultFileCacheControl
+}
+
+// fileQuery selects the user files:
+//
+//    `prefix` prefix of the file names
+//    `sort` "name", "size" or "uploaded_at", prefixed with "-" for descending order
+//    `after` cursor of the last file of the previous page
+//    `skip` number of skipped files
+//    `limit` maximum number of returned files
+//
+type fileQuery struct {
+	prefix string
+	sort   string
+	after  *filesCursor
+	skip   int
+	limit  int
+}
+
+// filesCursor points to the last file of the page. It holds the sort value along with the
+// file name, which orders files with the same value.
+type filesCursor struct {
+	Sort  string      `bson:"s"`
+	Value interface{} `bson:"v"`
+	Name  string      `bson:"n"`
+}
+
+func encodeFilesCursor(secret []byte, sort string, file *fileInfo) (string, error) {
+	cursor := filesCursor{Sort: sort, Value: file.Name, Name: file.Name}
+	switch strings.TrimPrefix(sort, "-") {
+	case "size":
+		cursor.Value = file.Size
+	case "uploaded_at":
+		cursor.Value = file.UploadedAt
+	}
+	bytes, err := bson.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return signCursor(secret, bytes), nil
+}
+
+// parseFilesCursor parses the cursor, which needs to be received with the same sort.
+func parseFilesCursor(secret []byte, value string, sort string) (*filesCursor, error) {
+	bytes, err := openCursor(secret, value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor filesCursor
+	err = bson.Unmarshal(bytes, &cursor)
+	if err != nil || cursor.Sort != sort {
+		return nil, errInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// userFilesPrefixRange returns Mongo condition matching the user file names starting with
+// the prefix. It's served by the "filename" index, since the upper bound is the prefix
+// with it's last character incremented.
+func userFilesPrefixRange(uid string, prefix string) bson.M {
+	start := uid + ":" + prefix
+	last, size := utf8.DecodeLastRuneInString(start)
+	next := last + 1
+	if next >= 0xD800 && next <= 0xDFFF { // surrogates are not valid characters
+		next = 0xE000
+	}
+	end := start[:len(start)-size] + string(next)
+	if last == utf8.MaxRune {
+		end = start + string(utf8.MaxRune)
+	}
+	return bson.M{"$gte": start, "$lt": end}
+}
+
+// selector returns Mongo selector of the user files matching the query.
+func (query *fileQuery) selector(uid string) (bson.M, error) {
+	sort := query.sort
+	if sort == "" {
+		sort = "name"
+	}
+	field, ok := fileSortFields[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		return nil, errUnsupportedSort
+	}
+	selector := bson.M{"filename": userFilesPrefixRange(uid, query.prefix)}
+	if query.after == nil {
+		return selector, nil
+	}
+
+	operator := "$gt"
+	if strings.HasPrefix(sort, "-") {
+		operator = "$lt"
+	}
+	afterName := uid + ":" + query.after.Name
+	if field == "filename" {
+		selector["filename"].(bson.M)[operator] = afterName
+		return selector, nil
+	}
+	selector["$or"] = []bson.M{
+		{field: bson.M{operator: query.after.Value}},
+		{field: query.after.Value, "filename": bson.M{operator: afterName}},
+	}
+	return selector, nil
+}
+
+// listFiles returns the user files matching the query.
+func (app *BasicApp) listFiles(uid string, query fileQuery) ([]fileInfo, error) {
+	selector, err := query.selector(uid)
+	if err != nil {
+		return nil, err
+	}
+	sort := query.sort
+	if sort == "" {
+		sort = "name"
+	}
+	field := fileSortFields[strings.TrimPrefix(sort, "-")]
+	order := []string{field, "filename"}
+	if strings.HasPrefix(sort, "-") {
+		order = []string{"-" + field, "-filename"}
+	}
+
+	var files []struct {
+		Filename    string       `bson:"filename"`
+		Length      int64        `bson:"length"`
+		ContentType string       `bson:"contentType"`
+		UploadDate  time.Time    `bson:"uploadDate"`
+		MD5         string       `bson:"md5"`
+		Metadata    fileMetadata `bson:"metadata"`
+	}
+	err = app.Coll.Files.Files.Find(selector).
+		Sort(order...).
+		Skip(query.skip).
+		Limit(query.limit).
+		Select(bson.M{"filename": 1, "length": 1, "contentType": 1, "uploadDate": 1, "md5": 1, "metadata": 1}).
+		All(&files)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]fileInfo, len(files))
+	for i, file := range files {
+		name := strings.TrimPrefix(file.Filename, uid+":")
+		contentType := file.ContentType
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(name))
+		}
+		infos[i] = fileInfo{
+			Name:        name,
+			Size:        file.Length,
+			ContentType: contentType,
+			UploadedAt:  file.UploadDate,
+			MD5:         file.MD5,
+		}
+		if file.Metadata.Data != nil {
+			infos[i].Metadata = decodeKeys(file.Metadata.Data)
+		}
+	}
+	return infos, nil
+}
+
+// countFiles returns number of the user files with names starting with the prefix.
+func (app *BasicApp) countFiles(uid string, prefix string) (int, error) {
+	return app.Coll.Files.Files.Find(bson.M{"filename": userFilesPrefixRange(uid, prefix)}).Count()
+}
@@ -0,0 +1,98 @@
+package basicserver
+
+import (
+	"strings"
+
+	"github.com/kataras/iris"
+)
+
+// ServeFilesGet serves
+// Method:   GET
+// Resource: http://localhost/api/files
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Authorization: Bearer {token}
+//
+// Files are listed with following optional parameters:
+//
+//    `prefix` prefix of the file names, e.g. "avatars/"
+//    `sort` "name", "size" or "uploaded_at", prefixed with "-" for descending order.
+//      Files are sorted by name by default.
+//    `limit` number of files to be returned, 50 by default and 1000 at most
+//    `cursor` cursor of the next page received with the previous page
+//
+// If everything goes well, then this will return status code `200` and `application/json`
+// response with the files. Id of the file is it's name, which can be passed to
+// /api/file/{id}. Cursor of the next page is returned if there are more files and it has
+// to be used with the same `sort` parameter:
+//
+//    {
+//      "files": [
+//        {
+//          "id": "avatars/golang.jpg",
+//          "size": 5793,
+//          "content_type": "image/jpeg",
+//          "uploaded_at": "2019-03-19T10:00:00Z",
+//          "md5": "1b8e8e5a4c4f8d8d4a3e8c0b8c5d6e7f"
+//        }
+//      ],
+//      "cursor": "..."
+//    }
+//
+// In case of error, this will return status code `400` or `500` and `text/plain` error
+// message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeFilesGet() iris.Handler {
+	return func(ctx iris.Context) {
+		uid := ctx.Values().Get("uid").(string)
+
+		query := fileQuery{prefix: ctx.URLParam("prefix"), sort: ctx.URLParamDefault("sort", "name")}
+		if _, ok := fileSortFields[strings.TrimPrefix(query.sort, "-")]; !ok {
+			app.HandleError(errUnsupportedSort, ctx, iris.StatusBadRequest)
+			ctx.WriteString(errUnsupportedSort.Error())
+			return
+		}
+		if cursor := ctx.URLParam("cursor"); cursor != "" {
+			after, err := parseFilesCursor(app.Settings.Secret, cursor, query.sort)
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusBadRequest)
+				ctx.WriteString(err.Error())
+				return
+			}
+			query.after = after
+		}
+
+		limit := ctx.URLParamIntDefault("limit", defaultFilesLimit)
+		if limit <= 0 {
+			limit = defaultFilesLimit
+		}
+		if limit > maxFilesLimit {
+			limit = maxFilesLimit
+		}
+		// one more file tells whether there is a next page
+		query.limit = limit + 1
+
+		files, err := app.listFiles(uid, query)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		response := iris.Map{}
+		if len(files) > limit {
+			files = files[:limit]
+			cursor, err := encodeFilesCursor(app.Settings.Secret, query.sort, &files[limit-1])
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+				return
+			}
+			response["cursor"] = cursor
+		}
+		response["files"] = files
+		ctx.JSON(response)
+	}
+}
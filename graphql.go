@@ -0,0 +1,479 @@
+package basicserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+const defaultGraphQLMaxDepth = 10
+const defaultGraphQLMaxComplexity = 200
+const defaultFilesPerPage = 20
+const maxFilesPerPage = 100
+
+var (
+	errQueryTooDeep    = errors.New("Query Too Deep")
+	errQueryTooComplex = errors.New("Query Too Complex")
+	errUnsupportedPage = errors.New("Unsupported Page")
+	errInvalidContent  = errors.New("Invalid Content")
+)
+
+// graphQLRequest is the authenticated request passed to the resolvers in the context.
+type graphQLRequest struct {
+	uid       bson.ObjectId
+	requestID string
+	policy    *UploadPolicy
+}
+
+type graphQLRequestKey struct{}
+
+func graphQLRequestFrom(ctx context.Context) *graphQLRequest {
+	return ctx.Value(graphQLRequestKey{}).(*graphQLRequest)
+}
+
+// jsonScalar is GraphQL scalar of any JSON value, such as the user data.
+var jsonScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Any JSON value.",
+	Serialize: func(value interface{}) interface{} {
+		return value
+	},
+	ParseValue: func(value interface{}) interface{} {
+		return value
+	},
+	ParseLiteral: parseJSONLiteral,
+})
+
+// parseJSONLiteral converts GraphQL literal to JSON value. Numbers are returned as float64,
+// the same as in the JSON request bodies.
+func parseJSONLiteral(value ast.Value) interface{} {
+	switch v := value.(type) {
+	case *ast.StringValue:
+		return v.Value
+	case *ast.BooleanValue:
+		return v.Value
+	case *ast.IntValue:
+		number, _ := strconv.ParseFloat(v.Value, 64)
+		return number
+	case *ast.FloatValue:
+		number, _ := strconv.ParseFloat(v.Value, 64)
+		return number
+	case *ast.ListValue:
+		list := make([]interface{}, len(v.Values))
+		for i, item := range v.Values {
+			list[i] = parseJSONLiteral(item)
+		}
+		return list
+	case *ast.ObjectValue:
+		object := make(map[string]interface{})
+		for _, field := range v.Fields {
+			object[field.Name.Value] = parseJSONLiteral(field.Value)
+		}
+		return object
+	}
+	return nil
+}
+
+// graphQLSchema builds GraphQL schema of the account, user data and user files. Resolvers
+// share the logic of the matching REST handlers.
+func (app *BasicApp) graphQLSchema() (graphql.Schema, error) {
+	usageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Usage",
+		Fields: graphql.Fields{
+			"fileBytes": &graphql.Field{Type: graphql.Int},
+			"fileCount": &graphql.Field{Type: graphql.Int},
+		},
+	})
+	accountType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Account",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: graphql.ID},
+			"email":       &graphql.Field{Type: graphql.String},
+			"lastLoginAt": &graphql.Field{Type: graphql.DateTime},
+			"usage": &graphql.Field{
+				Type: usageType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					usage, err := app.userUsage(graphQLRequestFrom(p.Context).uid)
+					if err != nil {
+						return nil, err
+					}
+					return map[string]interface{}{
+						"fileBytes": usage.FileBytes,
+						"fileCount": usage.FileCount,
+					}, nil
+				},
+			},
+		},
+	})
+	fileType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "File",
+		Fields: graphql.Fields{
+			"name":        &graphql.Field{Type: graphql.String},
+			"size":        &graphql.Field{Type: graphql.Int},
+			"contentType": &graphql.Field{Type: graphql.String},
+			"md5":         &graphql.Field{Type: graphql.String},
+			"uploadedAt":  &graphql.Field{Type: graphql.DateTime},
+			"metadata":    &graphql.Field{Type: jsonScalar},
+		},
+	})
+	filePageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "FilePage",
+		Fields: graphql.Fields{
+			"items":   &graphql.Field{Type: graphql.NewList(fileType)},
+			"total":   &graphql.Field{Type: graphql.Int},
+			"page":    &graphql.Field{Type: graphql.Int},
+			"perPage": &graphql.Field{Type: graphql.Int},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"me": &graphql.Field{
+				Type:    accountType,
+				Resolve: app.resolveMe,
+			},
+			"data": &graphql.Field{
+				Type: jsonScalar,
+				Args: graphql.FieldConfigArgument{
+					"path":      &graphql.ArgumentConfig{Type: graphql.String},
+					"namespace": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: app.resolveData,
+			},
+			"files": &graphql.Field{
+				Type: filePageType,
+				Args: graphql.FieldConfigArgument{
+					"filter":  &graphql.ArgumentConfig{Type: graphql.String},
+					"page":    &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 1},
+					"perPage": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultFilesPerPage},
+				},
+				Resolve: app.resolveFiles,
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"setData": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Sets dotted path keys of the user data and returns the new state version.",
+				Args: graphql.FieldConfigArgument{
+					"data":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(jsonScalar)},
+					"namespace": &graphql.ArgumentConfig{Type: graphql.String},
+					"version":   &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: app.resolveSetData,
+			},
+			"deleteData": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Removes dotted path keys, or all the user data, and returns the new state version.",
+				Args: graphql.FieldConfigArgument{
+					"keys":      &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+					"all":       &graphql.ArgumentConfig{Type: graphql.Boolean},
+					"namespace": &graphql.ArgumentConfig{Type: graphql.String},
+					"version":   &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: app.resolveDeleteData,
+			},
+			"uploadFile": &graphql.Field{
+				Type:        fileType,
+				Description: "Stores base64 encoded file content, overwriting the file with the same name.",
+				Args: graphql.FieldConfigArgument{
+					"name":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"content": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: app.resolveUploadFile,
+			},
+			"deleteFile": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Removes the file along with the links to it.",
+				Args: graphql.FieldConfigArgument{
+					"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: app.resolveDeleteFile,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    query,
+		Mutation: mutation,
+	})
+}
+
+func (app *BasicApp) resolveMe(p graphql.ResolveParams) (interface{}, error) {
+	var user User
+	err := app.Coll.Users.FindId(graphQLRequestFrom(p.Context).uid).One(&user)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"id":          user.ID.Hex(),
+		"email":       user.Email,
+		"lastLoginAt": user.LastLoginAt,
+	}, nil
+}
+
+func (app *BasicApp) resolveData(p graphql.ResolveParams) (interface{}, error) {
+	objectUID := graphQLRequestFrom(p.Context).uid
+	namespace, err := parseNamespace(stringArg(p.Args, "namespace"))
+	if err != nil {
+		return nil, err
+	}
+
+	query := app.Coll.States.Find(stateSelector(objectUID, namespace))
+	var path []string
+	var paths [][]string
+	if key := stringArg(p.Args, "path"); key != "" {
+		path, err = parseDataPath(key)
+		if err != nil {
+			return nil, err
+		}
+		paths = [][]string{path}
+		query = query.Select(dataProjection(paths))
+	}
+
+	var state State
+	err = query.One(&state)
+	if err == nil {
+		err = app.openState(&state, paths)
+	}
+	if err != nil && err.Error() != "not found" {
+		return nil, err
+	}
+
+	if path == nil {
+		if state.Data == nil { // state might not be existing yet
+			return map[string]interface{}{}, nil
+		}
+		return decodeKeys(state.Data), nil
+	}
+	value, ok := getPathValue(state.Data, encodePath(path))
+	if !ok {
+		return nil, nil
+	}
+	return decodeKeys(value), nil
+}
+
+func (app *BasicApp) resolveFiles(p graphql.ResolveParams) (interface{}, error) {
+	page, _ := p.Args["page"].(int)
+	perPage, _ := p.Args["perPage"].(int)
+	if page < 1 || perPage < 1 || perPage > maxFilesPerPage {
+		return nil, errUnsupportedPage
+	}
+
+	uid := graphQLRequestFrom(p.Context).uid.Hex()
+	prefix := stringArg(p.Args, "filter")
+	total, err := app.countFiles(uid, prefix)
+	if err != nil {
+		return nil, err
+	}
+	files, err := app.listFiles(uid, fileQuery{prefix: prefix, skip: (page - 1) * perPage, limit: perPage})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]interface{}, len(files))
+	for i, file := range files {
+		items[i] = fileValue(file)
+	}
+	return map[string]interface{}{
+		"items":   items,
+		"total":   total,
+		"page":    page,
+		"perPage": perPage,
+	}, nil
+}
+
+func (app *BasicApp) resolveSetData(p graphql.ResolveParams) (interface{}, error) {
+	input, ok := p.Args["data"].(map[string]interface{})
+	if !ok {
+		return nil, errUnsupportedInput
+	}
+	update, err := dataSetUpdate(bson.M(input), nil)
+	if err != nil {
+		return nil, err
+	}
+	return app.resolveStateWrite(p, update)
+}
+
+func (app *BasicApp) resolveDeleteData(p graphql.ResolveParams) (interface{}, error) {
+	var input interface{}
+	if all, _ := p.Args["all"].(bool); all {
+		input = true
+	} else if keys, ok := p.Args["keys"].([]interface{}); ok {
+		input = keys
+	}
+	update, err := dataUnsetUpdate(input)
+	if err != nil {
+		return nil, err
+	}
+	return app.resolveStateWrite(p, update)
+}
+
+// resolveStateWrite writes the user state, the same as the REST handlers do, and returns
+// the new state version. Optional `version` argument makes the write conditional.
+func (app *BasicApp) resolveStateWrite(p graphql.ResolveParams, update bson.M) (interface{}, error) {
+	namespace, err := parseNamespace(stringArg(p.Args, "namespace"))
+	if err != nil {
+		return nil, err
+	}
+	var condition *stateCondition
+	if version, ok := p.Args["version"].(int); ok {
+		condition = &stateCondition{versions: []int64{int64(version)}}
+	}
+
+	request := graphQLRequestFrom(p.Context)
+	state, err := app.updateState(request.uid, namespace, update, condition, request.requestID)
+	if err == errPreconditionFailed {
+		return nil, errors.New("Precondition Failed")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return state.Version, nil
+}
+
+func (app *BasicApp) resolveUploadFile(p graphql.ResolveParams) (interface{}, error) {
+	name := stringArg(p.Args, "name")
+	err := validateKey(name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := base64.StdEncoding.DecodeString(stringArg(p.Args, "content"))
+	if err != nil {
+		return nil, errInvalidContent
+	}
+
+	request := graphQLRequestFrom(p.Context)
+	objectUID := request.uid
+	_, err = app.storeFile(objectUID, fileUpload{
+		name:   name,
+		size:   int64(len(content)),
+		reader: bytes.NewReader(content),
+		policy: request.policy,
+	})
+	if err != nil {
+		return nil, err
+	}
+	files, err := app.listFiles(objectUID.Hex(), fileQuery{prefix: name, limit: 1})
+	if err != nil || len(files) == 0 || files[0].Name != name {
+		return nil, err
+	}
+	return fileValue(files[0]), nil
+}
+
+func (app *BasicApp) resolveDeleteFile(p graphql.ResolveParams) (interface{}, error) {
+	name := stringArg(p.Args, "name")
+	err := validateKey(name)
+	if err != nil {
+		return nil, err
+	}
+	err = app.removeFile(graphQLRequestFrom(p.Context).uid, name)
+	if err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+func fileValue(file fileInfo) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        file.Name,
+		"size":        file.Size,
+		"contentType": file.ContentType,
+		"md5":         file.MD5,
+		"uploadedAt":  file.UploadedAt,
+		"metadata":    file.Metadata,
+	}
+}
+
+func stringArg(args map[string]interface{}, name string) string {
+	value, _ := args[name].(string)
+	return value
+}
+
+// checkQueryLimits checks depth and complexity of the GraphQL query. Complexity is the
+// number of the selected fields, counting fragments every time they are spread.
+// Introspection fields are limited the same as any other, since nested types and fields of
+// the schema can be selected to any depth. Only `__typename` is not counted.
+// Queries which can't be parsed are left for the GraphQL executor to report.
+func checkQueryLimits(query string, maxDepth int, maxComplexity int) error {
+	document, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return nil
+	}
+	fragments := make(map[string]*ast.SelectionSet)
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok && fragment.Name != nil {
+			fragments[fragment.Name.Value] = fragment.SelectionSet
+		}
+	}
+
+	limits := &queryLimits{
+		fragments:     fragments,
+		spread:        make(map[string]bool),
+		maxDepth:      maxDepth,
+		maxComplexity: maxComplexity,
+	}
+	for _, definition := range document.Definitions {
+		if operation, ok := definition.(*ast.OperationDefinition); ok {
+			err = limits.check(operation.SelectionSet, 1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type queryLimits struct {
+	fragments     map[string]*ast.SelectionSet
+	spread        map[string]bool // fragments being spread, which guards against cycles
+	complexity    int
+	maxDepth      int
+	maxComplexity int
+}
+
+func (limits *queryLimits) check(selectionSet *ast.SelectionSet, depth int) error {
+	if selectionSet == nil {
+		return nil
+	}
+	for _, selection := range selectionSet.Selections {
+		var err error
+		switch s := selection.(type) {
+		case *ast.Field:
+			if s.Name == nil || s.Name.Value == "__typename" {
+				continue
+			}
+			if depth > limits.maxDepth {
+				return errQueryTooDeep
+			}
+			limits.complexity++
+			if limits.complexity > limits.maxComplexity {
+				return errQueryTooComplex
+			}
+			err = limits.check(s.SelectionSet, depth+1)
+		case *ast.InlineFragment:
+			err = limits.check(s.SelectionSet, depth)
+		case *ast.FragmentSpread:
+			if s.Name == nil || limits.spread[s.Name.Value] {
+				continue
+			}
+			limits.spread[s.Name.Value] = true
+			err = limits.check(limits.fragments[s.Name.Value], depth)
+			limits.spread[s.Name.Value] = false
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
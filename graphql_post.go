@@ -0,0 +1,128 @@
+package basicserver
+
+import (
+	"context"
+	"log"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/graphql-go/graphql"
+	"github.com/kataras/iris"
+)
+
+// ServeGraphQLPost serves
+// Method:   POST
+// Resource: http://localhost/graphql
+//
+// This resource is enabled with `GraphQL` setting and requires `Authorization` header, e.g.:
+//
+//		Content-Type: application/json
+//		Authorization: Bearer {token}
+//
+// Sample request to be `POST`ed as `application/json`:
+//
+//    {
+//      "query": "query ($path: String) { me { email } data(path: $path) files(filter: \"img\") { total items { name size } } }",
+//      "variables": { "path": "profile.name" }
+//    }
+//
+// Following queries and mutations are available, with the same rules and limits as the
+// matching REST resources:
+//
+//    `me` account of the user along with it's files usage
+//    `data(path, namespace)` user data, or single value under dotted path
+//    `files(filter, page, perPage)` page of the user files with names starting with the filter
+//    `setData(data, namespace, version)` the same as POST /api/data
+//    `deleteData(keys, all, namespace, version)` the same as DELETE /api/data
+//    `uploadFile(name, content)` the same as POST /api/file, with base64 encoded content
+//    `deleteFile(name)` the same as DELETE /api/file
+//
+// Optional `version` argument makes the data write conditional, the same as `If-Match`
+// header. Schema introspection is supported.
+//
+// Uploaded files are checked against `UploadPolicy` setting, or the policy of the route set
+// with `WithUploadPolicy`, the same as in POST /api/file. The whole request body is limited
+// to `MaxBodySize` of the policy, larger bodies return status code `413`.
+//
+// Queries are limited to `GraphQLMaxDepth` levels of nested fields (10 by default) and
+// `GraphQLMaxComplexity` selected fields (200 by default), including the introspection
+// fields. Full introspection queries of GraphQL IDEs might need higher limits.
+//
+// If the query is executed, then this will return status code `200` and `application/json`
+// response with the data and errors of the failed fields:
+//
+//    {
+//      "data": {
+//        "me": { "email": "foo@bar.baz" },
+//        "data": "Nick",
+//        "files": { "total": 1, "items": [ { "name": "img.jpg", "size": 5793 } ] }
+//      }
+//    }
+//
+// In case of malformed request or query exceeding the limits, this will return status code
+// `400` and `application/json` response with the errors:
+//
+//    {
+//      "errors": [ { "message": "Query Too Deep" } ]
+//    }
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeGraphQLPost() iris.Handler {
+	schema, err := app.graphQLSchema()
+	if err != nil {
+		log.Fatal(err)
+	}
+	maxDepth := app.Settings.GraphQLMaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultGraphQLMaxDepth
+	}
+	maxComplexity := app.Settings.GraphQLMaxComplexity
+	if maxComplexity <= 0 {
+		maxComplexity = defaultGraphQLMaxComplexity
+	}
+
+	return func(ctx iris.Context) {
+		var input struct {
+			Query         string                 `json:"query"`
+			Variables     map[string]interface{} `json:"variables"`
+			OperationName string                 `json:"operationName"`
+		}
+		// files are uploaded within the request body
+		policy := app.uploadPolicy(ctx)
+		body, err := policy.limitBody(ctx)
+		if err == nil {
+			err = ctx.ReadJSON(&input)
+			if body.exceeded {
+				err = errUploadTooLarge
+			}
+		}
+		if err == nil {
+			err = checkQueryLimits(input.Query, maxDepth, maxComplexity)
+		}
+		if err != nil {
+			status := iris.StatusBadRequest
+			if err == errUploadTooLarge {
+				status = iris.StatusRequestEntityTooLarge
+			}
+			app.HandleError(err, ctx, status)
+			ctx.JSON(iris.Map{"errors": []iris.Map{{"message": err.Error()}}})
+			return
+		}
+
+		uid := ctx.Values().Get("uid").(string)
+		request := &graphQLRequest{uid: bson.ObjectIdHex(uid), requestID: requestID(ctx), policy: policy}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  input.Query,
+			VariableValues: input.Variables,
+			OperationName:  input.OperationName,
+			Context:        context.WithValue(ctx.Request().Context(), graphQLRequestKey{}, request),
+		})
+		if result.Data == nil && result.HasErrors() { // query wasn't executed
+			ctx.StatusCode(iris.StatusBadRequest)
+		}
+		ctx.JSON(result)
+	}
+}
@@ -0,0 +1,252 @@
+package basicserver
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+const defaultHistorySnapshotInterval = 20
+
+var errVersionNotAvailable = errors.New("Version Not Available")
+
+// HistoryEntry is an entity of user state history. Every write to the user state
+// is recorded either as a full snapshot of the data or as the list of changes:
+//
+//    `ID` entry id
+//    `UID` user uid, or state id for the namespaces other than default
+//    `Version` state version after the write
+//    `RequestID` id of the request which changed the state
+//    `Full` whether the entry holds full snapshot of the data
+//    `Snapshot` user data after the write
+//    `Changes` changes made by the write
+//    `Encrypted` snapshot and changes encrypted with the user data key, which are stored
+//      instead of them when the encryption is enabled
+//    `CreatedAt` time at which the write happened
+//
+type HistoryEntry struct {
+	ID        bson.ObjectId   `bson:"_id" json:"-"`
+	UID       bson.ObjectId   `bson:"uid" json:"-"`
+	Version   int64           `bson:"version" json:"version"`
+	RequestID string          `bson:"request_id" json:"request_id"`
+	Full      bool            `bson:"full,omitempty" json:"-"`
+	Snapshot  bson.M          `bson:"snapshot,omitempty" json:"-"`
+	Changes   []HistoryChange `bson:"changes,omitempty" json:"-"`
+	Encrypted []byte          `bson:"encrypted,omitempty" json:"-"`
+	CreatedAt time.Time       `bson:"created_at" json:"created_at"`
+}
+
+// HistoryChange is a single change of the user data path. Empty path stands for
+// the whole user data.
+type HistoryChange struct {
+	Path  string      `bson:"path"`
+	Value interface{} `bson:"value"`
+	Unset bool        `bson:"unset,omitempty"`
+}
+
+// historyChanges returns changes made by the Mongo update, with values taken from the state
+// after the update. It returns nil if the whole user data was replaced.
+func historyChanges(update bson.M, state *State) []HistoryChange {
+	changes := []HistoryChange{}
+	for operator, fields := range update {
+		fieldsMap, ok := fields.(bson.M)
+		if !ok {
+			continue
+		}
+		for field := range fieldsMap {
+			path, ok := splitDataPath(field)
+			if !ok {
+				continue
+			}
+			if operator == "$unset" {
+				changes = append(changes, HistoryChange{Path: strings.Join(path, "."), Unset: true})
+				continue
+			}
+			if len(path) == 0 {
+				return nil
+			}
+			value, ok := getPathValue(state.Data, path)
+			changes = append(changes, HistoryChange{
+				Path:  strings.Join(path, "."),
+				Value: value,
+				Unset: !ok,
+			})
+		}
+	}
+	return changes
+}
+
+// applyHistoryChanges applies history changes to the user data.
+func applyHistoryChanges(data bson.M, changes []HistoryChange) bson.M {
+	var node interface{} = data
+	for _, change := range changes {
+		var path []string
+		if change.Path != "" {
+			path = strings.Split(change.Path, ".")
+		}
+		if change.Unset {
+			node = unsetPathValue(node, path)
+		} else {
+			node = setPathValue(node, path, change.Value)
+		}
+	}
+	result, _ := node.(bson.M)
+	return result
+}
+
+// recordHistory stores history entry of the state write and prunes entries which
+// exceed the retention settings.
+func (app *BasicApp) recordHistory(requestID string, update bson.M, state *State) error {
+	entry := HistoryEntry{
+		ID:        bson.NewObjectId(),
+		UID:       state.ID,
+		Version:   state.Version,
+		RequestID: requestID,
+		Changes:   historyChanges(update, state),
+		CreatedAt: state.UpdatedAt,
+	}
+
+	interval := int64(app.Settings.HistorySnapshotInterval)
+	if interval <= 0 {
+		interval = defaultHistorySnapshotInterval
+	}
+	entry.Full = entry.Changes == nil || state.Version == 1 || state.Version%interval == 0
+	if !entry.Full {
+		// previous versions might be written before history was recorded
+		count, err := app.Coll.History.Find(bson.M{
+			"uid":     state.ID,
+			"version": state.Version - 1,
+		}).Count()
+		if err != nil {
+			return err
+		}
+		entry.Full = count == 0
+	}
+	if entry.Full {
+		entry.Snapshot = state.Data
+		entry.Changes = nil
+	}
+	owner := stateOwner(state)
+	if app.encryptionEnabled() {
+		err := app.sealHistoryEntry(owner, &entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := app.Coll.History.Insert(entry)
+	if err != nil {
+		return err
+	}
+	return app.pruneHistory(owner, state.ID, state.Version)
+}
+
+// pruneHistory removes history entries older than `HistoryMaxCount` versions or
+// `HistoryMaxAge`. The oldest kept entry is turned into a snapshot, so all the kept
+// versions can be restored.
+func (app *BasicApp) pruneHistory(owner bson.ObjectId, objectUID bson.ObjectId, version int64) error {
+	var oldest int64
+	if app.Settings.HistoryMaxCount > 0 {
+		oldest = version - int64(app.Settings.HistoryMaxCount) + 1
+	}
+	if app.Settings.HistoryMaxAge > 0 {
+		var entry HistoryEntry
+		err := app.Coll.History.Find(bson.M{
+			"uid":        objectUID,
+			"created_at": bson.M{"$gte": time.Now().Add(-app.Settings.HistoryMaxAge)},
+		}).Sort("version").One(&entry)
+		if err == nil && entry.Version > oldest {
+			oldest = entry.Version
+		}
+	}
+	if oldest <= 1 {
+		return nil
+	}
+
+	count, err := app.Coll.History.Find(bson.M{
+		"uid":     objectUID,
+		"version": bson.M{"$lt": oldest},
+	}).Count()
+	if err != nil || count == 0 {
+		return err
+	}
+
+	data, err := app.stateAt(owner, objectUID, oldest)
+	if err == nil {
+		snapshot := HistoryEntry{UID: objectUID, Version: oldest, Snapshot: data}
+		update := bson.M{
+			"$set":   bson.M{"full": true, "snapshot": data},
+			"$unset": bson.M{"changes": "", "encrypted": ""},
+		}
+		if app.encryptionEnabled() {
+			err = app.sealHistoryEntry(owner, &snapshot)
+			update = bson.M{
+				"$set":   bson.M{"full": true, "encrypted": snapshot.Encrypted},
+				"$unset": bson.M{"changes": "", "snapshot": ""},
+			}
+		}
+		if err == nil {
+			err = app.Coll.History.Update(bson.M{"uid": objectUID, "version": oldest}, update)
+		}
+	}
+	if err != nil && err != errVersionNotAvailable {
+		return err
+	}
+
+	_, err = app.Coll.History.RemoveAll(bson.M{
+		"uid":     objectUID,
+		"version": bson.M{"$lt": oldest},
+	})
+	return err
+}
+
+// stateAt returns user data as of the given version. It returns `errVersionNotAvailable`
+// if the version is not recorded in history. Owner is the user uid, which is the same as
+// the state id for the default namespace.
+func (app *BasicApp) stateAt(owner bson.ObjectId, objectUID bson.ObjectId, version int64) (bson.M, error) {
+	var snapshot HistoryEntry
+	err := app.Coll.History.Find(bson.M{
+		"uid":     objectUID,
+		"version": bson.M{"$lte": version},
+		"full":    true,
+	}).Sort("-version").One(&snapshot)
+	if err != nil {
+		if err.Error() == "not found" {
+			return nil, errVersionNotAvailable
+		}
+		return nil, err
+	}
+
+	var entries []HistoryEntry
+	err = app.Coll.History.Find(bson.M{
+		"uid":     objectUID,
+		"version": bson.M{"$gt": snapshot.Version, "$lte": version},
+	}).Sort("version").All(&entries)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(entries)) != version-snapshot.Version {
+		return nil, errVersionNotAvailable
+	}
+	err = app.openHistoryEntry(owner, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		err = app.openHistoryEntry(owner, &entries[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data := snapshot.Snapshot
+	if data == nil {
+		data = bson.M{}
+	}
+	for _, entry := range entries {
+		data = applyHistoryChanges(data, entry.Changes)
+	}
+	return data, nil
+}
@@ -0,0 +1,64 @@
+package basicserver
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+const defaultHistoryLimit = 50
+
+// ServeHistoryGet serves
+// Method:   GET
+// Resource: http://localhost/api/history
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Authorization: Bearer {token}
+//
+// Optional `limit` query parameter limits number of returned versions (50 by default),
+// and optional `before` query parameter returns only versions older than the given one.
+//
+// If everything goes well, then this will return status code `200` and `application/json`
+// response with recorded state versions, newest first:
+//
+//    [
+//      {
+//        "version": 12,
+//        "request_id": "5c0a7922c9e77c0008d7b5a0",
+//        "created_at": "2018-11-30T09:39:42Z"
+//      }
+//    ]
+//
+// In case of error, this will return status code `500` and `text/plain` error
+// message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeHistoryGet() iris.Handler {
+	return func(ctx iris.Context) {
+		uid := ctx.Values().Get("uid").(string)
+
+		limit := ctx.URLParamIntDefault("limit", defaultHistoryLimit)
+		if limit <= 0 || limit > defaultHistoryLimit {
+			limit = defaultHistoryLimit
+		}
+		query := bson.M{"uid": bson.ObjectIdHex(uid)}
+		if before := ctx.URLParamInt64Default("before", 0); before > 0 {
+			query["version"] = bson.M{"$lt": before}
+		}
+
+		entries := []HistoryEntry{}
+		err := app.Coll.History.Find(query).
+			Select(bson.M{"version": 1, "request_id": 1, "created_at": 1}).
+			Sort("-version").
+			Limit(limit).
+			All(&entries)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		ctx.JSON(entries)
+	}
+}
@@ -0,0 +1,70 @@
+package basicserver
+
+import (
+	"strconv"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeHistoryRestorePost serves
+// Method:   POST
+// Resource: http://localhost/api/history/{version:string}/restore
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Authorization: Bearer {token}
+//
+// This replaces user data with the data as of the given version. The restore is stored
+// as a new write, so it can be reverted as well. Optional `If-Match` header makes the
+// restore conditional, the same way as in POST /api/data.
+//
+// If everything goes well, then this will return status code `200`, `ETag` header with
+// the new state version and no response body.
+//
+// In case of version which is not recorded in history, this will return status code `404`
+// and `text/plain` error message (e.g. "Version Not Available") as a response.
+//
+// In case of `If-Match` header which doesn't match the current state version, this will
+// return status code `412`.
+//
+// In case of configured schema which the data after the write doesn't match, this will
+// return status code `422` and `application/json` response with the violations, the same
+// as in POST /api/data.
+//
+// In case of error, this will return status code `500` and `text/plain` error
+// message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeHistoryRestorePost() iris.Handler {
+	return func(ctx iris.Context) {
+		uid := ctx.Values().Get("uid").(string)
+		objectUID := bson.ObjectIdHex(uid)
+
+		version, err := strconv.ParseInt(ctx.Params().Get("version"), 10, 64)
+		var data bson.M
+		if err != nil {
+			err = errVersionNotAvailable
+		} else {
+			data, err = app.stateAt(objectUID, objectUID, version)
+		}
+		if err != nil {
+			if err == errVersionNotAvailable {
+				app.HandleError(err, ctx, iris.StatusNotFound)
+				ctx.WriteString(err.Error())
+			} else {
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+			}
+			return
+		}
+
+		condition := parseStateCondition(ctx.GetHeader("If-Match"), false)
+		_, err = app.writeState(ctx, objectUID, "", bson.M{"$set": bson.M{"data": data}}, condition)
+		if err != nil {
+			app.handleStateError(err, ctx)
+			return
+		}
+	}
+}
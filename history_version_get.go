@@ -0,0 +1,59 @@
+package basicserver
+
+import (
+	"strconv"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeHistoryVersionGet serves
+// Method:   GET
+// Resource: http://localhost/api/history/{version:string}
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Authorization: Bearer {token}
+//
+// If everything goes well, then this will return status code `200` and `application/json`
+// response with the user data as of the given version:
+//
+//    {
+//      "foo": "bar",
+//      "bar": "foo"
+//    }
+//
+// In case of version which is not recorded in history, this will return status code `404`
+// and `text/plain` error message (e.g. "Version Not Available") as a response.
+//
+// In case of error, this will return status code `500` and `text/plain` error
+// message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeHistoryVersionGet() iris.Handler {
+	return func(ctx iris.Context) {
+		uid := ctx.Values().Get("uid").(string)
+		objectUID := bson.ObjectIdHex(uid)
+
+		version, err := strconv.ParseInt(ctx.Params().Get("version"), 10, 64)
+		if err != nil {
+			err = errVersionNotAvailable
+		} else {
+			var data bson.M
+			data, err = app.stateAt(objectUID, objectUID, version)
+			if err == nil {
+				ctx.JSON(decodeKeys(data))
+				return
+			}
+		}
+
+		if err == errVersionNotAvailable {
+			app.HandleError(err, ctx, iris.StatusNotFound)
+			ctx.WriteString(err.Error())
+			return
+		}
+		app.HandleError(err, ctx, iris.StatusInternalServerError)
+	}
+}
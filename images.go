@@ -0,0 +1,297 @@
+package basicserver
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// maxImagePixels limits size of the decoded originals, which take 4 bytes per pixel.
+	maxImagePixels = 16 * 1000 * 1000
+	// maxConcurrentResizes limits number of the originals decoded at once.
+	maxConcurrentResizes = 2
+	jpegQuality          = 85
+)
+
+// defaultImageSizes are allowed widths and heights of resized images.
+var defaultImageSizes = []int{32, 64, 128, 256, 512, 1024}
+
+var (
+	errUnsupportedImageSize   = errors.New("Unsupported Image Size")
+	errUnsupportedImageFit    = errors.New("Unsupported Image Fit")
+	errUnsupportedImageFormat = errors.New("Unsupported Image Format")
+	errUnsupportedImage       = errors.New("Unsupported Image")
+	errImageTooLarge          = errors.New("Image Too Large")
+)
+
+// imageFormats maps formats of resized images to their content types.
+var imageFormats = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+}
+
+// imageExtensions are file name extensions of the resized images formats.
+var imageExtensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+}
+
+// imageVariant is the resized variant of the image:
+//
+//    `width`, `height` bounding box of the variant, 0 stands for any size
+//    `fit` "contain" scales the image to fit the box, "cover" scales and crops it to
+//      fill the box, "fill" stretches it to the box
+//    `format` "jpeg", "png" or "gif", empty keeps the format of the original
+//
+type imageVariant struct {
+	width  int
+	height int
+	fit    string
+	format string
+}
+
+// imageVariantFrom returns variant requested with `w`, `h`, `fit` and `format` parameters.
+// Nil variant is returned if no size is requested.
+func (app *BasicApp) imageVariantFrom(ctx iris.Context) (*imageVariant, error) {
+	variant := &imageVariant{fit: ctx.URLParamDefault("fit", "contain"), format: ctx.URLParam("format")}
+	var err error
+	variant.width, err = app.imageSize(ctx.URLParam("w"))
+	if err != nil {
+		return nil, err
+	}
+	variant.height, err = app.imageSize(ctx.URLParam("h"))
+	if err != nil {
+		return nil, err
+	}
+	if variant.width == 0 && variant.height == 0 {
+		return nil, nil
+	}
+	if variant.fit != "contain" && variant.fit != "cover" && variant.fit != "fill" {
+		return nil, errUnsupportedImageFit
+	}
+	if variant.format == "jpg" {
+		variant.format = "jpeg"
+	}
+	if _, ok := imageFormats[variant.format]; !ok && variant.format != "" {
+		return nil, errUnsupportedImageFormat
+	}
+	return variant, nil
+}
+
+// imageSize parses width or height of the variant, which has to be one of `ImageSizes`.
+func (app *BasicApp) imageSize(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errUnsupportedImageSize
+	}
+	sizes := app.Settings.ImageSizes
+	if len(sizes) == 0 {
+		sizes = defaultImageSizes
+	}
+	for _, allowed := range sizes {
+		if size == allowed {
+			return size, nil
+		}
+	}
+	return 0, errUnsupportedImageSize
+}
+
+// key returns part of the cached variant name.
+func (variant *imageVariant) key() string {
+	return strconv.Itoa(variant.width) + "x" + strconv.Itoa(variant.height) + "-" + variant.fit + "." + variant.format
+}
+
+// thumbnailName returns stored file name of the cached variant. Variants are named after
+// checksum of the original, so a variant of the overwritten file is never served.
+func thumbnailName(uid string, checksum string, variant *imageVariant) string {
+	return "thumb:" + uid + ":" + checksum + ":" + variant.key()
+}
+
+// resizeImage decodes JPEG, PNG or GIF image and encodes it's resized variant. Only the
+// first frame of animated GIF images is kept. Content type of the variant is returned.
+func resizeImage(original io.ReadSeeker, variant *imageVariant) ([]byte, string, error) {
+	config, format, err := image.DecodeConfig(original)
+	if err != nil || config.Width == 0 || config.Height == 0 {
+		return nil, "", errUnsupportedImage
+	}
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return nil, "", errImageTooLarge
+	}
+	_, err = original.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, "", err
+	}
+	src, _, err := image.Decode(original)
+	if err != nil {
+		return nil, "", errUnsupportedImage
+	}
+
+	srcRect, width, height := variant.bounds(src.Bounds())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, srcRect, draw.Src, nil)
+
+	if variant.format != "" {
+		format = variant.format
+	}
+	var buffer bytes.Buffer
+	switch format {
+	case "png":
+		err = png.Encode(&buffer, dst)
+	case "gif":
+		err = gif.Encode(&buffer, dst, nil)
+	default:
+		format = "jpeg"
+		err = jpeg.Encode(&buffer, dst, &jpeg.Options{Quality: jpegQuality})
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return buffer.Bytes(), imageFormats[format], nil
+}
+
+// bounds returns part of the original image to be scaled, along with size of the variant.
+// Images are never enlarged to fit the box, only to cover or fill it.
+func (variant *imageVariant) bounds(src image.Rectangle) (image.Rectangle, int, int) {
+	srcWidth, srcHeight := float64(src.Dx()), float64(src.Dy())
+	scaleX, scaleY := math.Inf(1), math.Inf(1)
+	if variant.width > 0 {
+		scaleX = float64(variant.width) / srcWidth
+	}
+	if variant.height > 0 {
+		scaleY = float64(variant.height) / srcHeight
+	}
+
+	fit := variant.fit
+	if variant.width == 0 || variant.height == 0 { // box of single size can only be fitted
+		fit = "contain"
+	}
+	switch fit {
+	case "cover":
+		scale := math.Max(scaleX, scaleY)
+		cropWidth := int(math.Round(float64(variant.width) / scale))
+		cropHeight := int(math.Round(float64(variant.height) / scale))
+		cropWidth, cropHeight = minInt(cropWidth, src.Dx()), minInt(cropHeight, src.Dy())
+		x := src.Min.X + (src.Dx()-cropWidth)/2
+		y := src.Min.Y + (src.Dy()-cropHeight)/2
+		return image.Rect(x, y, x+cropWidth, y+cropHeight), variant.width, variant.height
+	case "fill":
+		return src, variant.width, variant.height
+	}
+	scale := math.Min(math.Min(scaleX, scaleY), 1)
+	width := int(math.Max(math.Round(srcWidth*scale), 1))
+	height := int(math.Max(math.Round(srcHeight*scale), 1))
+	return src, width, height
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// serveThumbnail serves resized variant of the user file. Variants are generated once and
+// stored until the original is overwritten or removed. Up to `maxConcurrentResizes`
+// variants are generated at once, other requests wait for them. Variants are served with
+// upload time of the original and name with extension of their format.
+func (app *BasicApp) serveThumbnail(ctx iris.Context, original *storedFile, uid string, name string, variant *imageVariant) {
+	fileName := uid + ":" + name
+	thumbName := thumbnailName(uid, original.MD5, variant)
+	if app.serveCachedThumbnail(ctx, original, thumbName, name) {
+		return
+	}
+
+	select {
+	case app.imageSlots <- struct{}{}:
+		defer func() { <-app.imageSlots }()
+	case <-ctx.Request().Context().Done():
+		return
+	}
+	// the variant might have been generated while waiting
+	if app.serveCachedThumbnail(ctx, original, thumbName, name) {
+		return
+	}
+
+	content, contentType, err := resizeImage(original, variant)
+	if err != nil {
+		switch err {
+		case errUnsupportedImage:
+			app.HandleError(err, ctx, iris.StatusUnsupportedMediaType)
+			ctx.WriteString(err.Error())
+		case errImageTooLarge:
+			app.HandleError(err, ctx, iris.StatusRequestEntityTooLarge)
+			ctx.WriteString(err.Error())
+		default:
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+		}
+		return
+	}
+
+	err = app.cacheThumbnail(thumbName, fileName, contentType, content)
+	if err != nil { // the variant is served anyway
+		app.Iris.Logger().Error(err)
+	}
+	// the same checksum is stored along with the cached variant
+	checksum := md5.Sum(content)
+	app.serveContent(ctx, bytes.NewReader(content), thumbnailFileName(name, contentType), contentType,
+		hex.EncodeToString(checksum[:]), original.UploadDate)
+}
+
+// serveCachedThumbnail serves the cached variant, if there is one.
+func (app *BasicApp) serveCachedThumbnail(ctx iris.Context, original *storedFile, thumbName string, name string) bool {
+	thumb, err := app.openFile(thumbName)
+	if err != nil {
+		return false
+	}
+	defer thumb.Close()
+	app.serveContent(ctx, thumb, thumbnailFileName(name, thumb.ContentType), thumb.ContentType,
+		thumb.MD5, original.UploadDate)
+	return true
+}
+
+// thumbnailFileName returns name of the original with extension of the variant content
+// type, e.g. "photo.png" of "photo.jpg" variant encoded as PNG.
+func thumbnailFileName(name string, contentType string) string {
+	ext := filepath.Ext(name)
+	if mime.TypeByExtension(ext) == contentType {
+		return name
+	}
+	for format, formatType := range imageFormats {
+		if formatType == contentType {
+			return strings.TrimSuffix(name, ext) + imageExtensions[format]
+		}
+	}
+	return name
+}
+
+// cacheThumbnail stores the variant along with name of it's original.
+func (app *BasicApp) cacheThumbnail(thumbName string, fileName string, contentType string, content []byte) error {
+	thumb := &storedFile{Filename: thumbName, ContentType: contentType, Metadata: bson.M{"original": fileName}}
+	return app.createFile(thumb, bytes.NewReader(content), int64(len(content)))
+}
+
+// removeThumbnails removes cached variants of the user file, given by it's full name.
+func (app *BasicApp) removeThumbnails(fileName string) error {
+	return app.removeStoredFiles(bson.M{"metadata.original": fileName})
+}
@@ -0,0 +1,60 @@
+package basicserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// Link is a public download link entity:
+//
+//    `ID` link id
+//    `UID` uid of the file owner
+//    `File` id of the linked file
+//    `Password` optional encrypted password
+//    `MaxDownloads` optional limit of downloads, 0 means unlimited
+//    `Downloads` number of downloads so far
+//    `ExpiresAt` time at which link expires
+//    `CreatedAt` time at which link was created
+//
+type Link struct {
+	ID           bson.ObjectId `bson:"_id" json:"id"`
+	UID          bson.ObjectId `bson:"uid" json:"-"`
+	File         string        `bson:"file" json:"file"`
+	Password     string        `bson:"password,omitempty" json:"-"`
+	MaxDownloads int           `bson:"max_downloads" json:"max_downloads"`
+	Downloads    int           `bson:"downloads" json:"downloads"`
+	ExpiresAt    time.Time     `bson:"expires_at" json:"expires_at"`
+	CreatedAt    time.Time     `bson:"created_at" json:"created_at"`
+}
+
+// Signature returns HMAC signature of the link id and it's expiration time.
+func (link *Link) Signature(secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(link.ID.Hex() + ":" + strconv.FormatInt(link.ExpiresAt.Unix(), 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Path returns signed path under which the link is served.
+func (link *Link) Path(secret []byte) string {
+	return "/link/" + link.ID.Hex() +
+		"?expires=" + strconv.FormatInt(link.ExpiresAt.Unix(), 10) +
+		"&signature=" + link.Signature(secret)
+}
+
+// absoluteURL prefixes path with `BaseURL` setting or with the request host.
+func (app *BasicApp) absoluteURL(ctx iris.Context, path string) string {
+	if app.Settings.BaseURL != "" {
+		return app.Settings.BaseURL + path
+	}
+	scheme := "http://"
+	if ctx.Request().TLS != nil {
+		scheme = "https://"
+	}
+	return scheme + ctx.Host() + path
+}
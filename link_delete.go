@@ -0,0 +1,54 @@
+package basicserver
+
+import (
+	"errors"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeLinkDelete serves
+// Method:   DELETE
+// Resource: http://localhost/api/link/{id:string}
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//    Authorization: Bearer {token}
+//
+// This revokes the link, so it can't be used anymore.
+//
+// If everything goes well, then this will return status code `200` and no response body.
+//
+// In case of error, this will return status code `404` or `500` and `text/plain` error
+// message (e.g. "No Such Link") as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeLinkDelete() iris.Handler {
+	return func(ctx iris.Context) {
+		linkID := ctx.Params().Get("id")
+		uid := ctx.Values().Get("uid").(string)
+
+		if !bson.IsObjectIdHex(linkID) {
+			err := errors.New("No Such Link")
+			app.HandleError(err, ctx, iris.StatusNotFound)
+			ctx.WriteString("No Such Link")
+			return
+		}
+
+		err := app.Coll.Links.Remove(bson.M{
+			"_id": bson.ObjectIdHex(linkID),
+			"uid": bson.ObjectIdHex(uid),
+		})
+		if err != nil {
+			if err.Error() == "not found" {
+				app.HandleError(err, ctx, iris.StatusNotFound)
+				ctx.WriteString("No Such Link")
+			} else {
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package basicserver
+
+import (
+	"crypto/hmac"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ServeLinkGet serves
+// Method:   GET, POST
+// Resource: http://localhost/link/{id:string}?expires={expires}&signature={signature}
+//
+// This resource doesn't require `Authorization` header. The url is returned by
+// `POST /api/file/{id:string}/link` resource.
+//
+// Password protected links require the password to be sent in `X-Link-Password` header,
+// or in `password` field of `application/x-www-form-urlencoded` POST request body, e.g.
+// from HTML form. The password is never accepted as a query parameter, so it doesn't leak
+// to logs and `Referer` headers.
+//
+// If everything goes well then we will receive status code 200 and response with the linked
+// file. Range requests are supported, unless the number of downloads is limited. Then each
+// request serves the whole file and counts as a download.
+//
+// In case of invalid signature or password, this will return status code `403` and
+// `text/plain` error message as a response.
+//
+// In case of expired, revoked or exhausted link, this will return status code `410` and
+// `text/plain` error message as a response.
+//
+func (app *BasicApp) ServeLinkGet() iris.Handler {
+	return func(ctx iris.Context) {
+		linkID := ctx.Params().Get("id")
+		expires, err := strconv.ParseInt(ctx.URLParam("expires"), 10, 64)
+		if err != nil || !bson.IsObjectIdHex(linkID) {
+			err = errors.New("Incorrect Link")
+			app.HandleError(err, ctx, iris.StatusForbidden)
+			ctx.WriteString("Incorrect Link")
+			return
+		}
+
+		unsigned := Link{ID: bson.ObjectIdHex(linkID), ExpiresAt: time.Unix(expires, 0)}
+		signature := unsigned.Signature(app.Settings.Secret)
+		if !hmac.Equal([]byte(signature), []byte(ctx.URLParam("signature"))) {
+			err = errors.New("Incorrect Link Signature")
+			app.HandleError(err, ctx, iris.StatusForbidden)
+			ctx.WriteString("Incorrect Link")
+			return
+		}
+		if time.Now().After(unsigned.ExpiresAt) {
+			err = errors.New("Link Expired")
+			app.HandleError(err, ctx, iris.StatusGone)
+			ctx.WriteString("Link Expired")
+			return
+		}
+
+		var link Link
+		err = app.Coll.Links.FindId(unsigned.ID).One(&link)
+		if err != nil {
+			if err.Error() == "not found" {
+				app.HandleError(err, ctx, iris.StatusGone)
+				ctx.WriteString("Link Revoked")
+			} else {
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+			}
+			return
+		}
+
+		if link.Password != "" {
+			password := ctx.GetHeader("X-Link-Password")
+			if password == "" && ctx.Method() == iris.MethodPost {
+				password = ctx.Request().PostFormValue("password")
+			}
+			err = bcrypt.CompareHashAndPassword([]byte(link.Password), []byte(password))
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusForbidden)
+				ctx.WriteString("Incorrect Password")
+				return
+			}
+		}
+
+		fileName := link.UID.Hex() + ":" + link.File
+		file, err := app.openFile(fileName)
+		if err != nil {
+			if err.Error() == "not found" {
+				app.HandleError(err, ctx, iris.StatusGone)
+				ctx.WriteString("No Such File")
+			} else {
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+			}
+			return
+		}
+		defer file.Close()
+
+		// every request of limited link is a download of the whole file, ranges would let
+		// the file be read in parts without using the downloads
+		if link.MaxDownloads > 0 {
+			ctx.Request().Header.Del("Range")
+			ctx.Request().Header.Del("If-Range")
+			_, err = app.Coll.Links.Find(bson.M{
+				"_id":       link.ID,
+				"downloads": bson.M{"$lt": link.MaxDownloads},
+			}).Apply(mgo.Change{
+				Update: bson.M{"$inc": bson.M{"downloads": 1}},
+			}, &link)
+			if err != nil {
+				if err == mgo.ErrNotFound {
+					app.HandleError(errors.New("Link Exhausted"), ctx, iris.StatusGone)
+					ctx.WriteString("Link Exhausted")
+				} else {
+					app.HandleError(err, ctx, iris.StatusInternalServerError)
+				}
+				return
+			}
+		}
+
+		app.serveFile(ctx, file, link.File)
+	}
+}
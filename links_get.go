@@ -0,0 +1,80 @@
+package basicserver
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeLinksGet serves
+// Method:   GET
+// Resource: http://localhost/api/links
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//    Authorization: Bearer {token}
+//
+// Optional `file` query parameter limits the result to the links of a single file.
+//
+// If everything goes well, then this will return status code `200` and `application/json`
+// response with the active links:
+//
+//    [
+//      {
+//        "id": "5c0a7922c9e77c0008d7b5a0",
+//        "file": "uploaded_image.jpg",
+//        "url": "http://localhost/link/5c0a7922c9e77c0008d7b5a0?expires=1543567182&signature=...",
+//        "protected": true,
+//        "max_downloads": 5,
+//        "downloads": 1,
+//        "expires_at": "2018-11-30T09:39:42Z",
+//        "created_at": "2018-11-29T09:39:42Z"
+//      }
+//    ]
+//
+// In case of error, this will return status code `500` and `text/plain` error
+// message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeLinksGet() iris.Handler {
+	return func(ctx iris.Context) {
+		uid := ctx.Values().Get("uid").(string)
+
+		query := bson.M{
+			"uid":        bson.ObjectIdHex(uid),
+			"expires_at": bson.M{"$gt": time.Now()},
+		}
+		if file := ctx.URLParam("file"); file != "" {
+			query["file"] = file
+		}
+
+		var links []Link
+		err := app.Coll.Links.Find(query).Sort("-created_at").All(&links)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		result := make([]iris.Map, 0, len(links))
+		for _, link := range links {
+			if link.MaxDownloads > 0 && link.Downloads >= link.MaxDownloads {
+				continue
+			}
+			result = append(result, iris.Map{
+				"id":            link.ID.Hex(),
+				"file":          link.File,
+				"url":           app.absoluteURL(ctx, link.Path(app.Settings.Secret)),
+				"protected":     link.Password != "",
+				"max_downloads": link.MaxDownloads,
+				"downloads":     link.Downloads,
+				"expires_at":    link.ExpiresAt,
+				"created_at":    link.CreatedAt,
+			})
+		}
+
+		ctx.JSON(result)
+	}
+}
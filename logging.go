@@ -1,9 +1,12 @@
 package basicserver
 
 import (
+	"github.com/globalsign/mgo/bson"
 	"github.com/kataras/iris"
 )
 
+const maxRequestIDLength = 128
+
 // HandleError logs the error and sets status code response header.
 func (app *BasicApp) HandleError(err error, ctx iris.Context, status int) {
 	app.Iris.Logger().Error(err)
@@ -14,3 +17,18 @@ func (app *BasicApp) HandleError(err error, ctx iris.Context, status int) {
 func (app *BasicApp) LogMessage(message string) {
 	app.Iris.Logger().Infof(message)
 }
+
+// requestID returns id of the request taken from `X-Request-ID` header. If the header
+// is missing, new id is generated and sent back in `X-Request-ID` response header.
+func requestID(ctx iris.Context) string {
+	if id, ok := ctx.Values().Get("request_id").(string); ok {
+		return id
+	}
+	id := ctx.GetHeader("X-Request-ID")
+	if id == "" || len(id) > maxRequestIDLength {
+		id = bson.NewObjectId().Hex()
+	}
+	ctx.Values().Set("request_id", id)
+	ctx.Header("X-Request-ID", id)
+	return id
+}
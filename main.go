@@ -2,6 +2,7 @@ package basicserver
 
 import (
 	"log"
+	"time"
 
 	"github.com/kataras/iris"
 
@@ -11,11 +12,25 @@ import (
 const usersCollection = "users"
 const statesCollection = "states"
 const filesCollection = "files"
+const linksCollection = "links"
+const historyCollection = "state_history"
+const usageCollection = "usage"
+const recordsCollection = "records"
+const dataKeysCollection = "data_keys"
+const deliveriesCollection = "webhook_deliveries"
+const uploadsCollection = "uploads"
 
 type collections struct {
-	Users  *mgo.Collection
-	States *mgo.Collection
-	Files  *mgo.GridFS
+	Users      *mgo.Collection
+	States     *mgo.Collection
+	Files      *mgo.GridFS
+	Links      *mgo.Collection
+	History    *mgo.Collection
+	Usage      *mgo.Collection
+	Records    *mgo.Collection
+	DataKeys   *mgo.Collection
+	Deliveries *mgo.Collection
+	Uploads    *mgo.Collection
 }
 
 // SMTPSettings values are used by BasicApp to send emails.
@@ -37,6 +52,32 @@ type SMTPSettings struct {
 //   `ServerPort` - port on which the server should listen to
 //   `RecoverTemplate` - html content to be sent along with password recovery email
 //   `SMTP` - SMTP configuration to send emails
+//   `BaseURL` - public url of the server used in generated links, e.g. "https://example.com"
+//   `HistoryMaxCount` - number of kept state history versions, 0 keeps all of them
+//   `HistoryMaxAge` - age of kept state history versions, 0 keeps all of them
+//   `HistorySnapshotInterval` - number of versions after which full state snapshot is stored (20 by default)
+//   `Schema` - JSON Schema which default namespace user data is validated against
+//   `KeySchemas` - JSON Schemas which values of given default namespace user data keys are validated against
+//   `SchemaVersion` - version of the schemas stored along with validated user data
+//   `Quota` - storage limits of every user, which might be overridden per user with `SetUserQuota`
+//   `EventsBufferSize` - number of recent events kept for resuming event streams (1024 by default)
+//   `AllowedOrigins` - origins of the other sites allowed to open event WebSockets, e.g. "https://example.com"
+//   `EncryptionKeys` - 32 bytes long master keys by their ids, which wrap the user data keys
+//   `EncryptionKeyID` - id of the master key used to encrypt user states, empty disables the encryption
+//   `TTLSweepInterval` - interval at which expired user data keys are removed (1 minute by default)
+//   `SyncTombstoneMaxAge` - age after which tombstones of removed keys and files are pruned, so older sync cursors get the whole user data (30 days by default)
+//   `GraphQL` - enables /graphql endpoint
+//   `GraphQLMaxDepth` - maximum depth of GraphQL queries (10 by default)
+//   `GraphQLMaxComplexity` - maximum number of fields selected by GraphQL queries (200 by default)
+//   `Webhooks` - endpoints which receive account, state and file events
+//   `WebhookMaxAttempts` - number of attempts after which webhook delivery fails (8 by default)
+//   `WebhookRetryInterval` - interval at which failed webhook deliveries are retried (10 seconds by default)
+//   `WebhookDeliveryMaxAge` - time after which delivered and failed webhook deliveries are removed from the log (7 days by default)
+//   `UploadExpiration` - time after which incomplete resumable uploads are removed (24 hours by default)
+//   `UploadPolicy` - size, type and name limits of uploaded files, which might be overridden per route with `WithUploadPolicy`
+//   `ImageSizes` - allowed widths and heights of resized images (32, 64, 128, 256, 512 and 1024 by default)
+//   `FileCacheControl` - `Cache-Control` header of downloaded files by content type, e.g. "image/*" ("private, no-cache" by default)
+//   `FileStorage` - store of the file contents: GridFS (default), local directory or S3-compatible bucket
 //
 type Settings struct {
 	LogLevel        string
@@ -46,6 +87,42 @@ type Settings struct {
 	ServerPort      string
 	RecoverTemplate string
 	SMTP            SMTPSettings
+	BaseURL         string
+
+	HistoryMaxCount         int
+	HistoryMaxAge           time.Duration
+	HistorySnapshotInterval int
+
+	Schema        string
+	KeySchemas    map[string]string
+	SchemaVersion int
+
+	Quota Quota
+
+	EventsBufferSize int
+	AllowedOrigins   []string
+
+	EncryptionKeys  map[string][]byte
+	EncryptionKeyID string
+
+	TTLSweepInterval    time.Duration
+	SyncTombstoneMaxAge time.Duration
+
+	GraphQL              bool
+	GraphQLMaxDepth      int
+	GraphQLMaxComplexity int
+
+	Webhooks              []Webhook
+	WebhookMaxAttempts    int
+	WebhookRetryInterval  time.Duration
+	WebhookDeliveryMaxAge time.Duration
+
+	UploadExpiration time.Duration
+	UploadPolicy     UploadPolicy
+
+	ImageSizes       []int
+	FileCacheControl map[string]string
+	FileStorage      FileStorageSettings
 }
 
 // BasicApp contains following fields:
@@ -53,15 +130,30 @@ type Settings struct {
 //   `Coll.Users` - MongoDB "users" collection
 //   `Coll.State` - MongoDB "states" collection
 //   `Coll.File` - MongoDB "files" collection
+//   `Coll.Links` - MongoDB "links" collection
+//   `Coll.History` - MongoDB "state_history" collection
+//   `Coll.Usage` - MongoDB "usage" collection
+//   `Coll.Records` - MongoDB "records" collection
+//   `Coll.DataKeys` - MongoDB "data_keys" collection
+//   `Coll.Deliveries` - MongoDB "webhook_deliveries" collection
+//   `Coll.Uploads` - MongoDB "uploads" collection
+//   `Storage` - store of the file contents chosen by `FileStorage` setting
 //   `Db` - MongoDB named database
 //   `Iris` - iris.Default() instance
 //   `Settings` - Settings passed as an argument
 //
 type BasicApp struct {
 	Coll     *collections
+	Storage  FileStore
 	Db       *mgo.Database
 	Iris     *iris.Application
 	Settings *Settings
+
+	schemas      *stateSchemas
+	events       *eventHub
+	transactions bool          // Mongo server runs multi-document transactions
+	webhookSlots chan struct{} // running first attempts of webhook deliveries
+	imageSlots   chan struct{} // running resizes of images
 }
 
 // CreateApp returns BasicApp.
@@ -73,6 +165,14 @@ type BasicApp struct {
 //   `Coll.Users` - MongoDB "users" collection
 //   `Coll.State` - MongoDB "states" collection
 //   `Coll.File` - MongoDB "files" collection
+//   `Coll.Links` - MongoDB "links" collection
+//   `Coll.History` - MongoDB "state_history" collection
+//   `Coll.Usage` - MongoDB "usage" collection
+//   `Coll.Records` - MongoDB "records" collection
+//   `Coll.DataKeys` - MongoDB "data_keys" collection
+//   `Coll.Deliveries` - MongoDB "webhook_deliveries" collection
+//   `Coll.Uploads` - MongoDB "uploads" collection
+//   `Storage` - store of the file contents chosen by `FileStorage` setting
 //   `Db` - MongoDB named database
 //   `Iris` - iris.Default() instance
 //   `Settings` - Settings passed as an argument
@@ -85,6 +185,16 @@ func CreateApp(settings *Settings) *BasicApp {
 		log.Fatal("ServerPort cannot be empty!")
 	}
 
+	schemas, err := compileSchemas(settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = checkEncryptionKeys(settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	session, err := mgo.Dial(settings.MongoString)
 	if err != nil {
 		log.Fatal(err)
@@ -94,28 +204,111 @@ func CreateApp(settings *Settings) *BasicApp {
 	usersC := db.C(usersCollection)
 	statesC := db.C(statesCollection)
 	filesC := db.GridFS(filesCollection)
+	linksC := db.C(linksCollection)
+	historyC := db.C(historyCollection)
+	usageC := db.C(usageCollection)
+	recordsC := db.C(recordsCollection)
+	dataKeysC := db.C(dataKeysCollection)
+	deliveriesC := db.C(deliveriesCollection)
+	uploadsC := db.C(uploadsCollection)
+
+	storage, err := NewFileStore(db, settings.FileStorage)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	usersC.EnsureIndex(mgo.Index{
 		Key:        []string{"recovery_code"},
 		Background: true,
 	})
 
+	statesC.EnsureIndex(mgo.Index{
+		Key:        []string{"uid", "namespace"},
+		Unique:     true,
+		Sparse:     true,
+		Background: true,
+	})
+	statesC.EnsureIndex(mgo.Index{
+		Key:        []string{"next_expiry"},
+		Sparse:     true,
+		Background: true,
+	})
+
 	filesC.Files.EnsureIndex(mgo.Index{
 		Key:        []string{"filename"},
 		Unique:     true,
 		DropDups:   true,
 		Background: true,
 	})
+	filesC.Files.EnsureIndex(mgo.Index{
+		Key:        []string{"metadata.original"},
+		Sparse:     true,
+		Background: true,
+	})
+
+	linksC.EnsureIndex(mgo.Index{
+		Key:        []string{"uid", "file"},
+		Background: true,
+	})
+	linksC.EnsureIndex(mgo.Index{
+		Key:         []string{"expires_at"},
+		ExpireAfter: time.Second,
+		Background:  true,
+	})
+
+	recordsC.EnsureIndex(mgo.Index{
+		Key:        []string{"uid", "collection", "_id"},
+		Background: true,
+	})
+
+	historyC.EnsureIndex(mgo.Index{
+		Key:        []string{"uid", "version"},
+		Unique:     true,
+		Background: true,
+	})
+
+	deliveriesC.EnsureIndex(mgo.Index{
+		Key:        []string{"status", "next_attempt_at"},
+		Background: true,
+	})
+	deliveriesC.EnsureIndex(mgo.Index{
+		Key:         []string{"expires_at"},
+		ExpireAfter: time.Second,
+		Background:  true,
+	})
+
+	uploadsC.EnsureIndex(mgo.Index{
+		Key:        []string{"uid"},
+		Background: true,
+	})
+	uploadsC.EnsureIndex(mgo.Index{
+		Key:        []string{"expires_at"},
+		Background: true,
+	})
 
 	app := &BasicApp{
 		Coll: &collections{
-			Users:  usersC,
-			States: statesC,
-			Files:  filesC,
+			Users:      usersC,
+			States:     statesC,
+			Files:      filesC,
+			Links:      linksC,
+			History:    historyC,
+			Usage:      usageC,
+			Records:    recordsC,
+			DataKeys:   dataKeysC,
+			Deliveries: deliveriesC,
+			Uploads:    uploadsC,
 		},
+		Storage:  storage,
 		Db:       db,
 		Iris:     iris.Default(),
 		Settings: settings,
+		schemas:  schemas,
+		events:   newEventHub(settings.EventsBufferSize),
+
+		transactions: transactionsSupported(db),
+		webhookSlots: make(chan struct{}, maxConcurrentWebhooks),
+		imageSlots:   make(chan struct{}, maxConcurrentResizes),
 	}
 
 	app.Iris.Logger().SetLevel(settings.LogLevel)
@@ -0,0 +1,56 @@
+package basicserver
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// defaultNamespace is the name of the namespace served by /api/data routes.
+const defaultNamespace = "default"
+
+var errUnsupportedNamespace = errors.New("Unsupported Namespace")
+
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// stateNamespace returns data namespace of the request. Default namespace is returned
+// as an empty string.
+func stateNamespace(ctx iris.Context) (string, error) {
+	return parseNamespace(ctx.Params().Get("namespace"))
+}
+
+// parseNamespace validates name of the data namespace. Default namespace is returned as
+// an empty string.
+func parseNamespace(namespace string) (string, error) {
+	if namespace == "" || namespace == defaultNamespace {
+		return "", nil
+	}
+	if !namespacePattern.MatchString(namespace) {
+		return "", errUnsupportedNamespace
+	}
+	return namespace, nil
+}
+
+// namespaceName returns name of the namespace as exposed by the API.
+func namespaceName(namespace string) string {
+	if namespace == "" {
+		return defaultNamespace
+	}
+	return namespace
+}
+
+// stateSelector returns Mongo selector of the user state in given namespace. State of the
+// default namespace is stored under user uid, the same as before namespaces were added.
+func stateSelector(objectUID bson.ObjectId, namespace string) bson.M {
+	if namespace == "" {
+		return bson.M{"_id": objectUID}
+	}
+	return bson.M{"uid": objectUID, "namespace": namespace}
+}
+
+// userStatesSelector returns Mongo selector of the user states in all namespaces.
+func userStatesSelector(objectUID bson.ObjectId) bson.M {
+	return bson.M{"$or": []bson.M{{"_id": objectUID}, {"uid": objectUID}}}
+}
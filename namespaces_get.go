@@ -0,0 +1,92 @@
+package basicserver
+
+import (
+	"sort"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// namespaceItem is an entry of data namespaces list.
+type namespaceItem struct {
+	Name      string    `json:"name"`
+	Size      int       `json:"size"`
+	Version   int64     `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ServeNamespacesGet serves
+// Method:   GET
+// Resource: http://localhost/api/namespaces
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Authorization: Bearer {token}
+//
+// If everything goes well, then this will return status code `200` and `application/json`
+// response with the list of user data namespaces sorted by name. Size is the size of
+// stored data in bytes:
+//
+//    [
+//      {
+//        "name": "default",
+//        "size": 2048,
+//        "version": 12,
+//        "updated_at": "2019-03-19T10:00:00Z"
+//      },
+//      {
+//        "name": "notes",
+//        "size": 512,
+//        "version": 3,
+//        "updated_at": "2019-03-19T11:00:00Z"
+//      }
+//    ]
+//
+// In case of error, this will return status code `500` and `text/plain` error
+// message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeNamespacesGet() iris.Handler {
+	return func(ctx iris.Context) {
+		uid := ctx.Values().Get("uid").(string)
+		objectUID := bson.ObjectIdHex(uid)
+
+		items := []namespaceItem{}
+		var state State
+		iter := app.Coll.States.Find(userStatesSelector(objectUID)).Iter()
+		for iter.Next(&state) {
+			err := app.openState(&state, nil)
+			if err != nil {
+				iter.Close()
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+				return
+			}
+			size, err := stateSize(state.Data)
+			if err != nil {
+				iter.Close()
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+				return
+			}
+			items = append(items, namespaceItem{
+				Name:      namespaceName(state.Namespace),
+				Size:      size,
+				Version:   state.Version,
+				UpdatedAt: state.UpdatedAt,
+			})
+			state = State{}
+		}
+		err := iter.Close()
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].Name < items[j].Name
+		})
+		ctx.JSON(items)
+	}
+}
@@ -0,0 +1,118 @@
+package basicserver
+
+import (
+	"errors"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+var (
+	errUnsupportedOperation = errors.New("Unsupported Operation")
+	errConflictingPaths     = errors.New("Conflicting Paths")
+)
+
+// atomicOperations maps supported operations to Mongo update operators.
+var atomicOperations = map[string]string{
+	"inc":         "$inc",
+	"dec":         "$inc",
+	"min":         "$min",
+	"max":         "$max",
+	"push":        "$push",
+	"pull":        "$pull",
+	"addToSet":    "$addToSet",
+	"setIfAbsent": "$set",
+}
+
+// atomicOperation is a single operation of POST /api/ops request.
+type atomicOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+
+	path []string
+}
+
+// parseAtomicOperations validates the operations and parses their paths. Paths can't
+// overlap, since Mongo doesn't allow to update the same path twice.
+func parseAtomicOperations(operations []atomicOperation) error {
+	if len(operations) == 0 {
+		return errUnsupportedOperation
+	}
+	err := validateKeysCount(len(operations))
+	if err != nil {
+		return err
+	}
+	for i := range operations {
+		operation := &operations[i]
+		if _, ok := atomicOperations[operation.Op]; !ok {
+			return errUnsupportedOperation
+		}
+		path, err := parseDataPath(operation.Path)
+		if err != nil {
+			return err
+		}
+		operation.path = path
+
+		switch operation.Op {
+		case "inc", "dec":
+			if operation.Value == nil {
+				operation.Value = float64(1)
+			}
+			number, ok := operation.Value.(float64)
+			if !ok {
+				return errUnsupportedOperation
+			}
+			if operation.Op == "dec" {
+				operation.Value = -number
+			}
+		case "min", "max", "push", "pull", "addToSet", "setIfAbsent":
+			depth := len(path)
+			if operation.Op == "push" || operation.Op == "addToSet" {
+				depth++ // the value becomes an array element
+			}
+			err = validateDataValue(operation.Value, depth)
+			if err != nil {
+				return err
+			}
+			operation.Value = encodeKeys(operation.Value)
+		}
+
+		for _, other := range operations[:i] {
+			if isPathPrefix(other.path, path) || isPathPrefix(path, other.path) {
+				return errConflictingPaths
+			}
+		}
+	}
+	return nil
+}
+
+// atomicUpdate returns Mongo update of the operations. Set-if-absent operations are
+// included only for the paths which are absent in the given data.
+func atomicUpdate(operations []atomicOperation, data bson.M) bson.M {
+	update := bson.M{}
+	for _, operation := range operations {
+		if operation.Op == "setIfAbsent" {
+			if _, ok := getPathValue(data, encodePath(operation.path)); ok {
+				continue
+			}
+		}
+		operator := atomicOperations[operation.Op]
+		fields, ok := update[operator].(bson.M)
+		if !ok {
+			fields = bson.M{}
+			update[operator] = fields
+		}
+		fields[dataPath(operation.path)] = operation.Value
+	}
+	return update
+}
+
+// atomicResult returns values of the operation paths in the given data.
+func atomicResult(operations []atomicOperation, data bson.M) map[string]interface{} {
+	result := make(map[string]interface{}, len(operations))
+	for _, operation := range operations {
+		value, _ := getPathValue(data, encodePath(operation.path))
+		result[operation.Path] = decodeKeys(value)
+	}
+	return result
+}
@@ -0,0 +1,484 @@
+package basicserver
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+var (
+	errPatchUnsupported = errors.New("Unsupported Patch")
+	errPatchTestFailed  = errors.New("Patch Test Failed")
+	errPatchNoPath      = errors.New("Patch Path Not Found")
+	errUnsupportedKey   = errors.New("Unsupported Key")
+)
+
+// jsonPatchOperation is a single RFC 6902 JSON Patch operation.
+type jsonPatchOperation struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  string           `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// dataOperation is a single Mongo update operation on user data path.
+// Values of `$set` operations are taken from the patched document.
+type dataOperation struct {
+	operator string
+	path     []string
+	value    interface{}
+}
+
+// dataUpdate collects Mongo update operations on user data paths.
+type dataUpdate struct {
+	ops []dataOperation
+}
+
+func (update *dataUpdate) add(operator string, path []string, value interface{}) {
+	update.ops = append(update.ops, dataOperation{
+		operator: operator,
+		path:     append([]string{}, path...),
+		value:    value,
+	})
+}
+
+// conflicting reports whether any two operations touch the same path
+// or one of them touches a parent of the other, which Mongo can't handle
+// in a single update.
+func (update *dataUpdate) conflicting() bool {
+	for i, a := range update.ops {
+		for _, b := range update.ops[i+1:] {
+			if isPathPrefix(a.path, b.path) || isPathPrefix(b.path, a.path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// topLevel replaces all the operations with `$set` and `$unset` of the touched
+// top level keys.
+func (update *dataUpdate) topLevel(result map[string]interface{}) {
+	keys := make(map[string]bool)
+	ops := update.ops
+	update.ops = nil
+	for _, op := range ops {
+		if len(op.path) == 0 {
+			update.ops = []dataOperation{{operator: "$set"}}
+			return
+		}
+		if keys[op.path[0]] {
+			continue
+		}
+		keys[op.path[0]] = true
+		if _, ok := result[op.path[0]]; ok {
+			update.add("$set", op.path[:1], nil)
+		} else {
+			update.add("$unset", op.path[:1], "")
+		}
+	}
+}
+
+// build returns Mongo update document. `result` is the document after the patch.
+// Paths and values follow the same rules and limits as in POST /api/data.
+func (update *dataUpdate) build(result map[string]interface{}) (bson.M, error) {
+	err := validateKeysCount(len(update.ops))
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range update.ops {
+		err := validateDataPath(op.path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if update.conflicting() {
+		update.topLevel(result)
+	}
+
+	updateInput := bson.M{}
+	for _, op := range update.ops {
+		operation, ok := updateInput[op.operator].(bson.M)
+		if !ok {
+			operation = bson.M{}
+			updateInput[op.operator] = operation
+		}
+		if op.operator == "$set" || op.operator == "$push" {
+			// pushed value is checked as a part of the patched array
+			patched, _ := patchGet(result, op.path)
+			err := validateDataValue(patched, len(op.path))
+			if err != nil {
+				return nil, err
+			}
+		}
+		value := op.value
+		if op.operator == "$set" {
+			value, _ = patchGet(result, op.path)
+			value = encodeKeys(value)
+		}
+		operation[dataPath(op.path)] = value
+	}
+	return updateInput, nil
+}
+
+// normalizeJSON converts stored document to plain JSON values with unescaped keys.
+func normalizeJSON(data bson.M) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	if data == nil {
+		return result, nil
+	}
+	bytes, err := json.Marshal(decodeKeys(data))
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(bytes, &result)
+	return result, err
+}
+
+// parseJSONPointer splits RFC 6901 JSON Pointer into unescaped segments.
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if pointer[0] != '/' {
+		return nil, errPatchUnsupported
+	}
+	segments := strings.Split(pointer[1:], "/")
+	for i, segment := range segments {
+		segment = strings.Replace(segment, "~1", "/", -1)
+		segments[i] = strings.Replace(segment, "~0", "~", -1)
+	}
+	return segments, nil
+}
+
+func arrayIndex(array []interface{}, segment string, allowEnd bool) (int, error) {
+	if allowEnd && segment == "-" {
+		return len(array), nil
+	}
+	i, err := strconv.Atoi(segment)
+	if err != nil || i < 0 || strconv.Itoa(i) != segment {
+		return 0, errPatchNoPath
+	}
+	if i > len(array) || (i == len(array) && !allowEnd) {
+		return 0, errPatchNoPath
+	}
+	return i, nil
+}
+
+func patchGet(node interface{}, path []string) (interface{}, error) {
+	for _, segment := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			value, ok := n[segment]
+			if !ok {
+				return nil, errPatchNoPath
+			}
+			node = value
+		case []interface{}:
+			i, err := arrayIndex(n, segment, false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, errPatchNoPath
+		}
+	}
+	return node, nil
+}
+
+func patchAdd(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			n[path[0]] = value
+			return n, nil
+		}
+		child, ok := n[path[0]]
+		if !ok {
+			return nil, errPatchNoPath
+		}
+		child, err := patchAdd(child, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = child
+		return n, nil
+	case []interface{}:
+		if len(path) == 1 {
+			i, err := arrayIndex(n, path[0], true)
+			if err != nil {
+				return nil, err
+			}
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		}
+		i, err := arrayIndex(n, path[0], false)
+		if err != nil {
+			return nil, err
+		}
+		child, err := patchAdd(n[i], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = child
+		return n, nil
+	}
+	return nil, errPatchNoPath
+}
+
+func patchReplace(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	if _, err := patchGet(node, path); err != nil {
+		return nil, err
+	}
+	parent, _ := patchGet(node, path[:len(path)-1])
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[path[len(path)-1]] = value
+	case []interface{}:
+		i, _ := arrayIndex(p, path[len(path)-1], false)
+		p[i] = value
+	}
+	return node, nil
+}
+
+func patchRemove(node interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, errPatchUnsupported
+	}
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[path[0]]
+		if !ok {
+			return nil, errPatchNoPath
+		}
+		if len(path) == 1 {
+			delete(n, path[0])
+			return n, nil
+		}
+		child, err := patchRemove(child, path[1:])
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = child
+		return n, nil
+	case []interface{}:
+		i, err := arrayIndex(n, path[0], false)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 1 {
+			return append(n[:i], n[i+1:]...), nil
+		}
+		child, err := patchRemove(n[i], path[1:])
+		if err != nil {
+			return nil, err
+		}
+		n[i] = child
+		return n, nil
+	}
+	return nil, errPatchNoPath
+}
+
+// deepCopyJSON copies plain JSON value, so it can be stored under another path.
+func deepCopyJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = deepCopyJSON(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = deepCopyJSON(item)
+		}
+		return result
+	}
+	return value
+}
+
+// addOperation translates adding value at path to Mongo update operation.
+func (update *dataUpdate) addOperation(doc interface{}, path []string, value interface{}) {
+	if len(path) > 0 {
+		parentPath := path[:len(path)-1]
+		if parent, _ := patchGet(doc, parentPath); parent != nil {
+			if array, ok := parent.([]interface{}); ok {
+				i, _ := arrayIndex(array, path[len(path)-1], true)
+				value = encodeKeys(value)
+				if i == len(array) {
+					update.add("$push", parentPath, value)
+				} else {
+					update.add("$push", parentPath, bson.M{
+						"$each":     []interface{}{value},
+						"$position": i,
+					})
+				}
+				return
+			}
+		}
+	}
+	update.add("$set", path, nil)
+}
+
+// removeOperation translates removing value at path to Mongo update operation.
+// Array elements can't be removed by index, so the whole array is set.
+func (update *dataUpdate) removeOperation(doc interface{}, path []string) {
+	parentPath := path[:len(path)-1]
+	if parent, _ := patchGet(doc, parentPath); parent != nil {
+		if _, ok := parent.([]interface{}); ok {
+			update.add("$set", parentPath, nil)
+			return
+		}
+	}
+	update.add("$unset", path, "")
+}
+
+// applyJSONPatch applies RFC 6902 JSON Patch operations to the document and returns
+// the patched document along with the matching Mongo update operations.
+func applyJSONPatch(data map[string]interface{}, operations []jsonPatchOperation) (map[string]interface{}, *dataUpdate, error) {
+	update := &dataUpdate{}
+	var doc interface{} = data
+	for _, operation := range operations {
+		path, err := parseJSONPointer(operation.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		var value interface{}
+		switch operation.Op {
+		case "add", "replace", "test":
+			if operation.Value == nil {
+				return nil, nil, errPatchUnsupported
+			}
+			err = json.Unmarshal(*operation.Value, &value)
+			if err != nil {
+				return nil, nil, errPatchUnsupported
+			}
+		case "move", "copy":
+			from, err := parseJSONPointer(operation.From)
+			if err != nil {
+				return nil, nil, err
+			}
+			value, err = patchGet(doc, from)
+			if err != nil {
+				return nil, nil, err
+			}
+			if operation.Op == "copy" {
+				value = deepCopyJSON(value)
+				break
+			}
+			if isPathPrefix(from, path) {
+				if len(from) == len(path) {
+					continue
+				}
+				return nil, nil, errPatchUnsupported
+			}
+			update.removeOperation(doc, from)
+			doc, err = patchRemove(doc, from)
+			if err != nil {
+				return nil, nil, err
+			}
+		case "remove":
+		default:
+			return nil, nil, errPatchUnsupported
+		}
+
+		switch operation.Op {
+		case "add", "move", "copy":
+			update.addOperation(doc, path, value)
+			doc, err = patchAdd(doc, path, value)
+		case "remove":
+			if len(path) > 0 {
+				update.removeOperation(doc, path)
+			}
+			doc, err = patchRemove(doc, path)
+		case "replace":
+			doc, err = patchReplace(doc, path, value)
+			if err == nil {
+				update.add("$set", path, nil)
+			}
+		case "test":
+			var current interface{}
+			current, err = patchGet(doc, path)
+			if err == nil && !reflect.DeepEqual(current, value) {
+				err = errPatchTestFailed
+			} else if err == errPatchNoPath {
+				err = errPatchTestFailed
+			}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	result, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, nil, errPatchUnsupported
+	}
+	return result, update, nil
+}
+
+// mergePatchOperations translates RFC 7396 merge patch to Mongo update operations.
+func (update *dataUpdate) mergePatchOperations(path []string, target interface{}, patch map[string]interface{}) {
+	targetMap, _ := target.(map[string]interface{})
+	for key, value := range patch {
+		keyPath := append(path[:len(path):len(path)], key)
+		if value == nil {
+			if _, ok := targetMap[key]; ok {
+				update.add("$unset", keyPath, "")
+			}
+			continue
+		}
+		valueMap, isMap := value.(map[string]interface{})
+		if _, targetIsMap := targetMap[key].(map[string]interface{}); isMap && targetIsMap {
+			update.mergePatchOperations(keyPath, targetMap[key], valueMap)
+			continue
+		}
+		update.add("$set", keyPath, nil)
+	}
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetMap, ok := target.(map[string]interface{})
+	if !ok {
+		targetMap = make(map[string]interface{})
+	}
+	for key, value := range patchMap {
+		if value == nil {
+			delete(targetMap, key)
+		} else {
+			targetMap[key] = mergePatch(targetMap[key], value)
+		}
+	}
+	return targetMap
+}
+
+// applyMergePatch applies RFC 7396 merge patch to the document and returns the patched
+// document along with the matching Mongo update operations.
+func applyMergePatch(data map[string]interface{}, patch interface{}) (map[string]interface{}, *dataUpdate, error) {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return nil, nil, errPatchUnsupported
+	}
+	update := &dataUpdate{}
+	update.mergePatchOperations([]string{}, data, patchMap)
+	result := mergePatch(data, patchMap).(map[string]interface{})
+	return result, update, nil
+}
@@ -0,0 +1,362 @@
+package basicserver
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Limits of the user data keys. Nesting depth counts both the path keys and the objects
+// and arrays nested in the value, so stored documents stay within Mongo limit of 100 levels.
+const (
+	maxKeyLength = 256
+	maxDataDepth = 64
+	maxDataKeys  = 1000
+)
+
+var (
+	errKeyTooLong  = errors.New("Key Too Long")
+	errDataTooDeep = errors.New("Data Nested Too Deep")
+	errTooManyKeys = errors.New("Too Many Keys")
+)
+
+// Keys are stored with dots and dollar signs escaped, since Mongo doesn't allow them
+// in field names.
+var (
+	keyEncoder = strings.NewReplacer("%", "%25", ".", "%2E", "$", "%24")
+	keyDecoder = strings.NewReplacer("%2E", ".", "%24", "$", "%25", "%")
+)
+
+func encodeKey(key string) string {
+	return keyEncoder.Replace(key)
+}
+
+func decodeKey(key string) string {
+	if !strings.Contains(key, "%") {
+		return key
+	}
+	return keyDecoder.Replace(key)
+}
+
+// encodeKeys returns copy of the value with all the object keys escaped for storage.
+func encodeKeys(value interface{}) interface{} {
+	return mapKeys(value, encodeKey)
+}
+
+// decodeKeys returns copy of the stored value with all the object keys unescaped. Nil
+// objects, e.g. the removed data, stay nil.
+func decodeKeys(value interface{}) interface{} {
+	return mapKeys(value, decodeKey)
+}
+
+func mapKeys(value interface{}, mapKey func(string) string) interface{} {
+	switch v := value.(type) {
+	case bson.M:
+		if v == nil {
+			return v
+		}
+		result := make(bson.M, len(v))
+		for key, item := range v {
+			result[mapKey(key)] = mapKeys(item, mapKey)
+		}
+		return result
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[mapKey(key)] = mapKeys(item, mapKey)
+		}
+		return result
+	case []interface{}:
+		if v == nil {
+			return v
+		}
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = mapKeys(item, mapKey)
+		}
+		return result
+	}
+	return value
+}
+
+// validateKey checks a single key of the user data. Keys are non-empty UTF-8 strings
+// of up to `maxKeyLength` bytes without null characters. Dots and dollar signs are allowed,
+// since keys are escaped before they are passed to Mongo.
+func validateKey(key string) error {
+	if key == "" || !utf8.ValidString(key) || strings.IndexByte(key, 0) >= 0 {
+		return errUnsupportedKey
+	}
+	if len(key) > maxKeyLength {
+		return errKeyTooLong
+	}
+	return nil
+}
+
+// validateDataPath checks all the keys of the path and it's depth.
+func validateDataPath(path []string) error {
+	if len(path) > maxDataDepth {
+		return errDataTooDeep
+	}
+	for _, key := range path {
+		err := validateKey(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateDataValue checks the object keys of the value to be stored at given depth
+// of the user data, along with it's nesting depth.
+func validateDataValue(value interface{}, depth int) error {
+	if depth > maxDataDepth {
+		return errDataTooDeep
+	}
+	switch v := value.(type) {
+	case bson.M:
+		return validateDataValue(map[string]interface{}(v), depth)
+	case map[string]interface{}:
+		for key, item := range v {
+			err := validateKey(key)
+			if err != nil {
+				return err
+			}
+			err = validateDataValue(item, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			err := validateDataValue(item, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateKeysCount checks the number of keys given in a single request.
+func validateKeysCount(count int) error {
+	if count > maxDataKeys {
+		return errTooManyKeys
+	}
+	return nil
+}
+
+// parseDataPath splits dotted path into keys. Dots which are a part of the key are
+// escaped with backslash, e.g. `files.avatar\.jpg` stands for "avatar.jpg" key
+// of "files" object. Keys are checked with validateKey.
+func parseDataPath(path string) ([]string, error) {
+	var segments []string
+	var segment strings.Builder
+	escaped := false
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case escaped:
+			segment.WriteByte(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '.':
+			segments = append(segments, segment.String())
+			segment.Reset()
+		default:
+			segment.WriteByte(c)
+		}
+	}
+	if escaped {
+		return nil, errUnsupportedKey
+	}
+	segments = append(segments, segment.String())
+	err := validateDataPath(segments)
+	if err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+var pathEscaper = strings.NewReplacer(`\`, `\\`, ".", `\.`)
+
+// formatDataPath joins keys into dotted path, escaping dots and backslashes which are
+// a part of the keys. It's the reverse of parseDataPath.
+func formatDataPath(path []string) string {
+	escaped := make([]string, len(path))
+	for i, key := range path {
+		escaped[i] = pathEscaper.Replace(key)
+	}
+	return strings.Join(escaped, ".")
+}
+
+// splitEscaped splits string on separators which are not escaped with backslash.
+// Escape sequences are kept, so the parts can be parsed with parseDataPath.
+func splitEscaped(s string, separator byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == separator {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// encodePath escapes all the keys of the path.
+func encodePath(path []string) []string {
+	encoded := make([]string, len(path))
+	for i, key := range path {
+		encoded[i] = encodeKey(key)
+	}
+	return encoded
+}
+
+// dataPath returns Mongo path of the user data field. The keys are escaped.
+func dataPath(path []string) string {
+	if len(path) == 0 {
+		return "data"
+	}
+	return "data." + strings.Join(encodePath(path), ".")
+}
+
+// dataProjection returns Mongo projection of the user data paths along with the
+// state metadata. Paths nested in the other projected paths are skipped, since Mongo
+// doesn't allow such projections. Encrypted data is projected by openState.
+func dataProjection(paths [][]string) bson.M {
+	projection := bson.M{"version": 1, "updated_at": 1, "uid": 1, "namespace": 1, "encrypted": 1, "expires": 1}
+	for i, path := range paths {
+		nested := false
+		for j, other := range paths {
+			if i != j && isPathPrefix(other, path) && (len(other) < len(path) || j < i) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			projection[dataPath(path)] = 1
+		}
+	}
+	return projection
+}
+
+// splitDataPath splits Mongo path of the user data field into escaped segments. It returns
+// false if the field is not a part of user data.
+func splitDataPath(field string) ([]string, bool) {
+	if field == "data" {
+		return []string{}, true
+	}
+	if !strings.HasPrefix(field, "data.") {
+		return nil, false
+	}
+	return strings.Split(field[len("data."):], "."), true
+}
+
+func isPathPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// getPathValue returns value stored under the path of bson or JSON document.
+func getPathValue(node interface{}, path []string) (interface{}, bool) {
+	for _, segment := range path {
+		switch n := node.(type) {
+		case bson.M:
+			value, ok := n[segment]
+			if !ok {
+				return nil, false
+			}
+			node = value
+		case map[string]interface{}:
+			value, ok := n[segment]
+			if !ok {
+				return nil, false
+			}
+			node = value
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil, false
+			}
+			node = n[i]
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// setPathValue sets value under the path of bson document, creating missing parents the
+// same way as Mongo `$set` does. It returns the updated node.
+func setPathValue(node interface{}, path []string, value interface{}) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+	switch n := node.(type) {
+	case bson.M:
+		n[path[0]] = setPathValue(n[path[0]], path[1:], value)
+		return n
+	case map[string]interface{}:
+		n[path[0]] = setPathValue(n[path[0]], path[1:], value)
+		return n
+	case []interface{}:
+		i, err := strconv.Atoi(path[0])
+		if err == nil && i >= 0 {
+			for len(n) <= i {
+				n = append(n, nil)
+			}
+			n[i] = setPathValue(n[i], path[1:], value)
+			return n
+		}
+	}
+	return bson.M{path[0]: setPathValue(nil, path[1:], value)}
+}
+
+// unsetPathValue removes value under the path of bson document the same way as Mongo
+// `$unset` does. It returns the updated node.
+func unsetPathValue(node interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return nil
+	}
+	switch n := node.(type) {
+	case bson.M:
+		if len(path) == 1 {
+			delete(n, path[0])
+		} else if child, ok := n[path[0]]; ok {
+			n[path[0]] = unsetPathValue(child, path[1:])
+		}
+	case map[string]interface{}:
+		if len(path) == 1 {
+			delete(n, path[0])
+		} else if child, ok := n[path[0]]; ok {
+			n[path[0]] = unsetPathValue(child, path[1:])
+		}
+	case []interface{}:
+		// Mongo sets removed array elements to null
+		i, err := strconv.Atoi(path[0])
+		if err == nil && i >= 0 && i < len(n) {
+			if len(path) == 1 {
+				n[i] = nil
+			} else {
+				n[i] = unsetPathValue(n[i], path[1:])
+			}
+		}
+	}
+	return node
+}
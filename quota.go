@@ -0,0 +1,226 @@
+package basicserver
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+var (
+	errFileTooLarge      = errors.New("File Too Large")
+	errFileQuotaExceeded = errors.New("File Quota Exceeded")
+	errStateTooLarge     = errors.New("State Too Large")
+)
+
+// Quota limits storage available to an user. Zero values stand for the values from
+// `Quota` setting, negative values stand for no limit:
+//
+//    `FileBytes` total size of user files
+//    `FileCount` number of user files
+//    `FileSize` size of a single file
+//    `StateBytes` size of user state document
+//
+type Quota struct {
+	FileBytes  int64 `bson:"file_bytes,omitempty" json:"file_bytes"`
+	FileCount  int   `bson:"file_count,omitempty" json:"file_count"`
+	FileSize   int64 `bson:"file_size,omitempty" json:"file_size"`
+	StateBytes int   `bson:"state_bytes,omitempty" json:"state_bytes"`
+}
+
+// override returns the quota with values overridden by non zero values of other quota.
+func (quota Quota) override(other *Quota) Quota {
+	if other == nil {
+		return quota
+	}
+	if other.FileBytes != 0 {
+		quota.FileBytes = other.FileBytes
+	}
+	if other.FileCount != 0 {
+		quota.FileCount = other.FileCount
+	}
+	if other.FileSize != 0 {
+		quota.FileSize = other.FileSize
+	}
+	if other.StateBytes != 0 {
+		quota.StateBytes = other.StateBytes
+	}
+	return quota
+}
+
+// limits returns the quota with no limit values set to `0`.
+func (quota Quota) limits() Quota {
+	if quota.FileBytes < 0 {
+		quota.FileBytes = 0
+	}
+	if quota.FileCount < 0 {
+		quota.FileCount = 0
+	}
+	if quota.FileSize < 0 {
+		quota.FileSize = 0
+	}
+	if quota.StateBytes < 0 {
+		quota.StateBytes = 0
+	}
+	return quota
+}
+
+// Usage is an user's storage usage entity. Files usage is tracked incrementally
+// with every upload and removal:
+//
+//    `UID` user uid
+//    `FileBytes` total size of user files
+//    `FileCount` number of user files
+//
+type Usage struct {
+	UID       bson.ObjectId `bson:"_id" json:"-"`
+	FileBytes int64         `bson:"file_bytes" json:"file_bytes"`
+	FileCount int           `bson:"file_count" json:"file_count"`
+}
+
+// userQuota returns quota of the user, which is `Quota` setting with user overrides.
+func (app *BasicApp) userQuota(objectUID bson.ObjectId) (Quota, error) {
+	var user User
+	err := app.Coll.Users.FindId(objectUID).Select(bson.M{"quota": 1}).One(&user)
+	if err != nil && err.Error() != "not found" {
+		return Quota{}, err
+	}
+	return app.Settings.Quota.override(user.Quota), nil
+}
+
+// SetUserQuota overrides `Quota` setting for the user. Nil quota removes the overrides.
+func (app *BasicApp) SetUserQuota(objectUID bson.ObjectId, quota *Quota) error {
+	if quota == nil {
+		return app.Coll.Users.UpdateId(objectUID, bson.M{"$unset": bson.M{"quota": ""}})
+	}
+	return app.Coll.Users.UpdateId(objectUID, bson.M{"$set": bson.M{"quota": quota}})
+}
+
+// userUsage returns files usage of the user. Usage of users who uploaded files before it
+// was tracked is computed from the stored files.
+func (app *BasicApp) userUsage(objectUID bson.ObjectId) (Usage, error) {
+	var usage Usage
+	err := app.Coll.Usage.FindId(objectUID).One(&usage)
+	if err == nil || err.Error() != "not found" {
+		return usage, err
+	}
+
+	uid := objectUID.Hex()
+	var totals []struct {
+		Bytes int64 `bson:"bytes"`
+		Count int   `bson:"count"`
+	}
+	err = app.Coll.Files.Files.Pipe([]bson.M{
+		{"$match": bson.M{"filename": userFilesRange(uid)}},
+		{"$group": bson.M{
+			"_id":   nil,
+			"bytes": bson.M{"$sum": "$length"},
+			"count": bson.M{"$sum": 1},
+		}},
+	}).All(&totals)
+	if err != nil {
+		return usage, err
+	}
+	usage = Usage{UID: objectUID}
+	if len(totals) > 0 {
+		usage.FileBytes = totals[0].Bytes
+		usage.FileCount = totals[0].Count
+	}
+
+	// usage might be stored meanwhile by another request
+	_, err = app.Coll.Usage.UpsertId(objectUID, bson.M{"$setOnInsert": bson.M{
+		"file_bytes": usage.FileBytes,
+		"file_count": usage.FileCount,
+	}})
+	if err != nil {
+		return usage, err
+	}
+	err = app.Coll.Usage.FindId(objectUID).One(&usage)
+	return usage, err
+}
+
+// userFilesRange returns Mongo condition matching all the user file names. Unlike regular
+// expression it's served by the "filename" index, since ";" directly follows ":".
+func userFilesRange(uid string) bson.M {
+	return bson.M{"$gt": uid + ":", "$lt": uid + ";"}
+}
+
+// reserveFileQuota checks whether the file of given size can be stored by the user, and adds
+// given bytes and number of files to the files usage in the same atomic update, unless they
+// would exceed the quota. So concurrent uploads can't exceed the quota together. The
+// reservation has to be released with trackFileUsage if the file fails to be stored, or
+// corrected if it's stored with different size.
+func (app *BasicApp) reserveFileQuota(objectUID bson.ObjectId, size int64, bytes int64, count int) error {
+	quota, err := app.userQuota(objectUID)
+	if err != nil {
+		return err
+	}
+	if quota.FileSize > 0 && size > quota.FileSize {
+		return errFileTooLarge
+	}
+	// make sure usage of the files stored so far is counted
+	_, err = app.userUsage(objectUID)
+	if err != nil {
+		return err
+	}
+
+	selector := bson.M{"_id": objectUID}
+	if quota.FileBytes > 0 && bytes > 0 {
+		selector["file_bytes"] = bson.M{"$lte": quota.FileBytes - bytes}
+	}
+	if quota.FileCount > 0 && count > 0 {
+		selector["file_count"] = bson.M{"$lte": quota.FileCount - count}
+	}
+	err = app.Coll.Usage.Update(selector, bson.M{"$inc": bson.M{
+		"file_bytes": bytes,
+		"file_count": count,
+	}})
+	if err == mgo.ErrNotFound {
+		return errFileQuotaExceeded
+	}
+	return err
+}
+
+// trackFileUsage updates files usage of the user by given difference.
+func (app *BasicApp) trackFileUsage(objectUID bson.ObjectId, bytes int64, count int) error {
+	// make sure usage of the files stored so far is counted
+	_, err := app.userUsage(objectUID)
+	if err != nil {
+		return err
+	}
+	return app.Coll.Usage.UpdateId(objectUID, bson.M{"$inc": bson.M{
+		"file_bytes": bytes,
+		"file_count": count,
+	}})
+}
+
+// stateSize returns size of the stored user data.
+func stateSize(data bson.M) (int, error) {
+	bytes, err := bson.Marshal(bson.M{"data": data})
+	return len(bytes), err
+}
+
+// isTooLargeError reports whether Mongo refused to store too large document.
+func isTooLargeError(err error) bool {
+	if queryError, ok := err.(*mgo.QueryError); ok && (queryError.Code == 10334 || queryError.Code == 17419) {
+		return true
+	}
+	message := err.Error()
+	return strings.Contains(message, "too large") || strings.Contains(message, "larger than")
+}
+
+// handleQuotaError handles error returned by quota check.
+func (app *BasicApp) handleQuotaError(err error, ctx iris.Context) {
+	switch err {
+	case errFileTooLarge, errStateTooLarge:
+		app.HandleError(err, ctx, iris.StatusRequestEntityTooLarge)
+		ctx.WriteString(err.Error())
+	case errFileQuotaExceeded:
+		app.HandleError(err, ctx, iris.StatusInsufficientStorage)
+		ctx.WriteString(err.Error())
+	default:
+		app.HandleError(err, ctx, iris.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,383 @@
+package basicserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+const (
+	defaultRecordsLimit = 20
+	maxRecordsLimit     = 100
+	maxFilterDepth      = 8
+	maxSortFields       = 4
+)
+
+var (
+	errUnsupportedCollection = errors.New("Unsupported Collection")
+	errUnsupportedFilter     = errors.New("Unsupported Filter")
+	errUnsupportedSort       = errors.New("Unsupported Sort")
+	errInvalidCursor         = errors.New("Invalid Cursor")
+	errNoSuchRecord          = errors.New("No Such Record")
+)
+
+// Record is an entity of user records collection:
+//
+//    `ID` record id
+//    `UID` user uid
+//    `Collection` name of the collection
+//    `Data` record data
+//    `CreatedAt` time at which the record was created
+//    `UpdatedAt` time at which last write happened
+//
+type Record struct {
+	ID         bson.ObjectId `bson:"_id" json:"id"`
+	UID        bson.ObjectId `bson:"uid" json:"-"`
+	Collection string        `bson:"collection" json:"-"`
+	Data       bson.M        `bson:"data" json:"data"`
+	CreatedAt  time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time     `bson:"updated_at" json:"updated_at"`
+}
+
+// decoded returns the record with data keys unescaped.
+func (record Record) decoded() Record {
+	if record.Data == nil {
+		record.Data = bson.M{}
+	}
+	record.Data = decodeKeys(record.Data).(bson.M)
+	return record
+}
+
+// recordsCollectionName returns name of the records collection of the request. Names
+// follow the same rules as data namespace names.
+func recordsCollectionName(ctx iris.Context) (string, error) {
+	name := ctx.Params().Get("name")
+	if !namespacePattern.MatchString(name) {
+		return "", errUnsupportedCollection
+	}
+	return name, nil
+}
+
+// recordSelector returns Mongo selector of the user record of the request. It returns
+// `errNoSuchRecord` if the id is malformed.
+func recordSelector(ctx iris.Context, objectUID bson.ObjectId, collection string) (bson.M, error) {
+	id := ctx.Params().Get("id")
+	if !bson.IsObjectIdHex(id) {
+		return nil, errNoSuchRecord
+	}
+	selector := recordsSelector(objectUID, collection)
+	selector["_id"] = bson.ObjectIdHex(id)
+	return selector, nil
+}
+
+// recordsSelector returns Mongo selector of the user records in given collection.
+func recordsSelector(objectUID bson.ObjectId, collection string) bson.M {
+	return bson.M{"uid": objectUID, "collection": collection}
+}
+
+// filterOperators are the only Mongo operators allowed in records filter.
+var filterOperators = map[string]bool{
+	"$eq":     true,
+	"$ne":     true,
+	"$gt":     true,
+	"$gte":    true,
+	"$lt":     true,
+	"$lte":    true,
+	"$in":     true,
+	"$nin":    true,
+	"$exists": true,
+}
+
+// parseRecordsFilter translates JSON filter of the record data into Mongo query. Keys are
+// dotted paths of the record data. Values are compared for equality, unless they are
+// objects of the allowed operators. Filters can be combined with `$and`, `$or` and `$nor`.
+// Any other operator, e.g. `$where`, is rejected with `errUnsupportedFilter`.
+func parseRecordsFilter(filter string) (bson.M, error) {
+	var input map[string]interface{}
+	err := json.Unmarshal([]byte(filter), &input)
+	if err != nil || input == nil {
+		return nil, errUnsupportedFilter
+	}
+	return translateFilter(input, 1)
+}
+
+func translateFilter(filter map[string]interface{}, depth int) (bson.M, error) {
+	if depth > maxFilterDepth {
+		return nil, errUnsupportedFilter
+	}
+	query := bson.M{}
+	for key, value := range filter {
+		switch key {
+		case "$and", "$or", "$nor":
+			items, ok := value.([]interface{})
+			if !ok || len(items) == 0 {
+				return nil, errUnsupportedFilter
+			}
+			filters := make([]bson.M, len(items))
+			for i, item := range items {
+				itemFilter, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, errUnsupportedFilter
+				}
+				var err error
+				filters[i], err = translateFilter(itemFilter, depth+1)
+				if err != nil {
+					return nil, err
+				}
+			}
+			query[key] = filters
+			continue
+		}
+		if strings.HasPrefix(key, "$") {
+			return nil, errUnsupportedFilter
+		}
+		path, err := parseDataPath(key)
+		if err != nil {
+			return nil, errUnsupportedFilter
+		}
+		condition, err := translateCondition(value)
+		if err != nil {
+			return nil, err
+		}
+		query[dataPath(path)] = condition
+	}
+	return query, nil
+}
+
+func translateCondition(value interface{}) (interface{}, error) {
+	operators, ok := value.(map[string]interface{})
+	if !ok {
+		return value, nil
+	}
+	count := 0
+	for key := range operators {
+		if strings.HasPrefix(key, "$") {
+			count++
+		}
+	}
+	if count > 0 && count < len(operators) {
+		return nil, errUnsupportedFilter
+	}
+	if count == 0 {
+		// object value is compared with the stored one, which has escaped keys
+		return encodeKeys(value), nil
+	}
+
+	condition := bson.M{}
+	for operator, operand := range operators {
+		if !filterOperators[operator] {
+			return nil, errUnsupportedFilter
+		}
+		switch operator {
+		case "$in", "$nin":
+			if _, ok := operand.([]interface{}); !ok {
+				return nil, errUnsupportedFilter
+			}
+		case "$exists":
+			if _, ok := operand.(bool); !ok {
+				return nil, errUnsupportedFilter
+			}
+		}
+		condition[operator] = encodeKeys(operand)
+	}
+	return condition, nil
+}
+
+// sortField is a single field of records sort.
+type sortField struct {
+	Field string
+	Desc  bool
+}
+
+// parseRecordsSort parses comma separated list of fields to sort the records by. Fields
+// prefixed with "-" are sorted in descending order. Fields are dotted paths of the record
+// data, or "id", "created_at" and "updated_at". Records are always sorted by id last, so
+// the order is stable.
+func parseRecordsSort(sort string) ([]sortField, error) {
+	fields := []sortField{}
+	if sort != "" {
+		for _, key := range splitEscaped(sort, ',') {
+			field := sortField{}
+			if strings.HasPrefix(key, "-") {
+				field.Desc = true
+				key = key[1:]
+			}
+			switch key {
+			case "id":
+				field.Field = "_id"
+			case "created_at", "updated_at":
+				field.Field = key
+			default:
+				path, err := parseDataPath(key)
+				if err != nil {
+					return nil, errUnsupportedSort
+				}
+				field.Field = dataPath(path)
+			}
+			fields = append(fields, field)
+			if field.Field == "_id" {
+				break
+			}
+		}
+	}
+	if len(fields) > maxSortFields {
+		return nil, errUnsupportedSort
+	}
+	if len(fields) == 0 || fields[len(fields)-1].Field != "_id" {
+		fields = append(fields, sortField{Field: "_id"})
+	}
+	return fields, nil
+}
+
+// value returns value of the sort field of the record.
+func (field sortField) value(record *Record) interface{} {
+	switch field.Field {
+	case "_id":
+		return record.ID
+	case "created_at":
+		return record.CreatedAt
+	case "updated_at":
+		return record.UpdatedAt
+	}
+	path, _ := splitDataPath(field.Field)
+	value, _ := getPathValue(record.Data, path)
+	return value
+}
+
+// mongoSort returns sort fields in mgo Sort format.
+func mongoSort(fields []sortField) []string {
+	sort := make([]string, len(fields))
+	for i, field := range fields {
+		sort[i] = field.Field
+		if field.Desc {
+			sort[i] = "-" + field.Field
+		}
+	}
+	return sort
+}
+
+// recordsCursor points to the last record of the page. It holds values of the sort
+// fields, so the next page is found by the index rather than skipped.
+type recordsCursor struct {
+	Sort   string        `bson:"s"`
+	Values []interface{} `bson:"v"`
+}
+
+func encodeRecordsCursor(secret []byte, sort string, fields []sortField, record *Record) (string, error) {
+	cursor := recordsCursor{Sort: sort, Values: make([]interface{}, len(fields))}
+	for i, field := range fields {
+		cursor.Values[i] = field.value(record)
+	}
+	bytes, err := bson.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return signCursor(secret, bytes), nil
+}
+
+// parseRecordsCursor returns Mongo query of the records following the cursor. The cursor
+// needs to be received with the same sort.
+func parseRecordsCursor(secret []byte, value string, sort string, fields []sortField) (bson.M, error) {
+	bytes, err := openCursor(secret, value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor recordsCursor
+	err = bson.Unmarshal(bytes, &cursor)
+	if err != nil || cursor.Sort != sort || len(cursor.Values) != len(fields) {
+		return nil, errInvalidCursor
+	}
+
+	var or []bson.M
+	for i, field := range fields {
+		condition := bson.M{}
+		for j := 0; j < i; j++ {
+			// null matches missing fields as well
+			condition[fields[j].Field] = cursor.Values[j]
+		}
+		value := cursor.Values[i]
+		switch {
+		case value == nil && field.Desc:
+			// nothing follows missing values in descending order
+			continue
+		case value == nil:
+			condition[field.Field] = bson.M{"$ne": nil}
+		case field.Desc:
+			// missing values follow the others in descending order
+			condition[field.Field] = bson.M{"$not": bson.M{"$gte": value}}
+		default:
+			condition[field.Field] = bson.M{"$gt": value}
+		}
+		or = append(or, condition)
+	}
+	return bson.M{"$or": or}, nil
+}
+
+// signCursor returns base64 encoded cursor with it's HMAC signature. Cursor values end up
+// in Mongo queries, so they're only accepted as issued by the server.
+func signCursor(secret []byte, cursor []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(cursor)
+	return base64.RawURLEncoding.EncodeToString(cursor) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// openCursor returns the cursor signed by signCursor.
+func openCursor(secret []byte, value string) ([]byte, error) {
+	i := strings.LastIndex(value, ".")
+	if i < 0 {
+		return nil, errInvalidCursor
+	}
+	cursor, err := base64.RawURLEncoding.DecodeString(value[:i])
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(value[i+1:])
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(cursor)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, errInvalidCursor
+	}
+	return cursor, nil
+}
+
+// recordsProjection returns Mongo projection of comma separated record data paths along
+// with the record metadata. Sort fields are always included, since the cursor is made of
+// them.
+func recordsProjection(keys string, fields []sortField) (bson.M, error) {
+	var paths [][]string
+	for _, key := range splitEscaped(keys, ',') {
+		path, err := parseDataPath(key)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	for _, field := range fields {
+		if path, ok := splitDataPath(field.Field); ok {
+			for i := range path {
+				path[i] = decodeKey(path[i])
+			}
+			paths = append(paths, path)
+		}
+	}
+	projection := dataProjection(paths)
+	delete(projection, "version")
+	delete(projection, "namespace")
+	delete(projection, "encrypted")
+	delete(projection, "expires")
+	projection["uid"] = 1
+	projection["collection"] = 1
+	projection["created_at"] = 1
+	return projection, nil
+}
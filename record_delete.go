@@ -0,0 +1,55 @@
+package basicserver
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeRecordDelete serves
+// Method:   DELETE
+// Resource: http://localhost/api/collections/{name:string}/{id:string}
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Authorization: Bearer {token}
+//
+// If everything goes well, then this will return status code `200` and no response body.
+//
+// In case of record which doesn't exist, this will return status code `404` and
+// `text/plain` error message (e.g. "No Such Record") as a response.
+//
+// In case of error, this will return status code `400` or `500` and `text/plain` error
+// message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeRecordDelete() iris.Handler {
+	return func(ctx iris.Context) {
+		collection, err := recordsCollectionName(ctx)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		uid := ctx.Values().Get("uid").(string)
+		selector, err := recordSelector(ctx, bson.ObjectIdHex(uid), collection)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusNotFound)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		err = app.Coll.Records.Remove(selector)
+		if err != nil {
+			if err.Error() == "not found" {
+				app.HandleError(errNoSuchRecord, ctx, iris.StatusNotFound)
+				ctx.WriteString(errNoSuchRecord.Error())
+				return
+			}
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package basicserver
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeRecordGet serves
+// Method:   GET
+// Resource: http://localhost/api/collections/{name:string}/{id:string}
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Authorization: Bearer {token}
+//
+// If everything goes well, then this will return status code `200` and `application/json`
+// response with the record:
+//
+//    {
+//      "id": "5c90a7cde3fd3c4e4c7d4b5f",
+//      "data": {
+//        "title": "Buy milk",
+//        "done": false
+//      },
+//      "created_at": "2019-03-19T10:00:00Z",
+//      "updated_at": "2019-03-19T10:00:00Z"
+//    }
+//
+// In case of record which doesn't exist, this will return status code `404` and
+// `text/plain` error message (e.g. "No Such Record") as a response.
+//
+// In case of error, this will return status code `400` or `500` and `text/plain` error
+// message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeRecordGet() iris.Handler {
+	return func(ctx iris.Context) {
+		collection, err := recordsCollectionName(ctx)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		uid := ctx.Values().Get("uid").(string)
+		selector, err := recordSelector(ctx, bson.ObjectIdHex(uid), collection)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusNotFound)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		var record Record
+		err = app.Coll.Records.Find(selector).One(&record)
+		if err != nil {
+			if err.Error() == "not found" {
+				app.HandleError(errNoSuchRecord, ctx, iris.StatusNotFound)
+				ctx.WriteString(errNoSuchRecord.Error())
+				return
+			}
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		ctx.JSON(record.decoded())
+	}
+}
@@ -0,0 +1,103 @@
+package basicserver
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeRecordPatch serves
+// Method:   PATCH
+// Resource: http://localhost/api/collections/{name:string}/{id:string}
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Content-Type: application/json
+//		Authorization: Bearer {token}
+//
+// In order to update some of the record values, they need to be `PATCH`ed as
+// `application/json`. Keys are dotted paths, the same as keys in POST /api/data:
+//
+//    {
+//      "done": true,
+//      "details.note": "two bottles"
+//    }
+//
+// If everything goes well, then this will return status code `200` and `application/json`
+// response with the updated record, the same as GET request does.
+//
+// In case of record which doesn't exist, this will return status code `404` and
+// `text/plain` error message (e.g. "No Such Record") as a response.
+//
+// In case of error, this will return status code `400` or `500` and `text/plain` error
+// message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeRecordPatch() iris.Handler {
+	return func(ctx iris.Context) {
+		collection, err := recordsCollectionName(ctx)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		var input bson.M
+		err = ctx.ReadJSON(&input)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			return
+		}
+
+		err = validateKeysCount(len(input))
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		setInput := bson.M{"updated_at": time.Now()}
+		for key, value := range input {
+			path, err := parseDataPath(key)
+			if err == nil {
+				err = validateDataValue(value, len(path))
+			}
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusBadRequest)
+				ctx.WriteString(err.Error())
+				return
+			}
+			setInput[dataPath(path)] = encodeKeys(value)
+		}
+
+		uid := ctx.Values().Get("uid").(string)
+		selector, err := recordSelector(ctx, bson.ObjectIdHex(uid), collection)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusNotFound)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		var record Record
+		_, err = app.Coll.Records.Find(selector).Apply(mgo.Change{
+			Update:    bson.M{"$set": setInput},
+			ReturnNew: true,
+		}, &record)
+		if err != nil {
+			if err.Error() == "not found" {
+				app.HandleError(errNoSuchRecord, ctx, iris.StatusNotFound)
+				ctx.WriteString(errNoSuchRecord.Error())
+				return
+			}
+			// e.g. a path which goes through non object value
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			return
+		}
+
+		ctx.JSON(record.decoded())
+	}
+}
@@ -0,0 +1,92 @@
+package basicserver
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeRecordPut serves
+// Method:   PUT
+// Resource: http://localhost/api/collections/{name:string}/{id:string}
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Content-Type: application/json
+//		Authorization: Bearer {token}
+//
+// In order to replace the record data, the new data needs to be `PUT` as `application/json`:
+//
+//    {
+//      "title": "Buy milk",
+//      "done": true
+//    }
+//
+// If everything goes well, then this will return status code `200` and `application/json`
+// response with the updated record, the same as GET request does.
+//
+// In case of record which doesn't exist, this will return status code `404` and
+// `text/plain` error message (e.g. "No Such Record") as a response.
+//
+// In case of error, this will return status code `400` or `500` and `text/plain` error
+// message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeRecordPut() iris.Handler {
+	return func(ctx iris.Context) {
+		collection, err := recordsCollectionName(ctx)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		var input bson.M
+		err = ctx.ReadJSON(&input)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			return
+		}
+		if input == nil {
+			input = bson.M{}
+		}
+		err = validateDataValue(input, 0)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		uid := ctx.Values().Get("uid").(string)
+		selector, err := recordSelector(ctx, bson.ObjectIdHex(uid), collection)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusNotFound)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		var record Record
+		_, err = app.Coll.Records.Find(selector).Apply(mgo.Change{
+			Update: bson.M{"$set": bson.M{
+				"data":       encodeKeys(input),
+				"updated_at": time.Now(),
+			}},
+			ReturnNew: true,
+		}, &record)
+		if err != nil {
+			if err.Error() == "not found" {
+				app.HandleError(errNoSuchRecord, ctx, iris.StatusNotFound)
+				ctx.WriteString(errNoSuchRecord.Error())
+				return
+			}
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		ctx.JSON(record.decoded())
+	}
+}
@@ -12,9 +12,10 @@ import (
 )
 
 type registerInput struct {
-	Email     string    `bson:"email"`
-	Password  string    `bson:"password"`
-	CreatedAt time.Time `bson:"created_at"`
+	ID        bson.ObjectId `bson:"_id" json:"-"`
+	Email     string        `bson:"email"`
+	Password  string        `bson:"password"`
+	CreatedAt time.Time     `bson:"created_at"`
 }
 
 var (
@@ -74,6 +75,7 @@ func (app *BasicApp) ServeRegisterPost() iris.Handler {
 			return
 		}
 
+		input.ID = bson.NewObjectId()
 		input.Password = string(passEnc)
 		input.CreatedAt = time.Now()
 		err = app.Coll.Users.Insert(input)
@@ -83,5 +85,6 @@ func (app *BasicApp) ServeRegisterPost() iris.Handler {
 		}
 
 		app.LogMessage("User " + inputEmail + " registered.")
+		app.publishAccountEvent(input.ID, "register", inputEmail)
 	}
 }
@@ -15,9 +15,48 @@ import (
 //    `POST /api/data` serves to update user state
 //    `POST /api/file` serves to upload user file
 //    `GET /api/data` serves to get user data
+//    `GET /api/data/{namespace:string}` serves to get user data of given namespace
+//    `GET /api/data/{namespace:string}/{path:string}` serves to get single user data value
 //    `GET /api/file/{id:string}` serves to get user file
+//    `HEAD /api/file/{id:string}` serves to get user file headers without the content
+//    `GET /api/files` serves to list user files
 //    `DELETE /api/data` serves to delete user data
 //    `DELETE /api/file` serves to delete user file
+//    `PATCH /api/data` serves to patch user data with JSON Patch or JSON Merge Patch
+//    `POST /api/file/{id:string}/link` serves to create public link to user file
+//    `GET /api/links` serves to list active links to user files
+//    `DELETE /api/link/{id:string}` serves to revoke link to user file
+//    `GET /link/{id:string}` serves to get file through public link
+//    `POST /link/{id:string}` serves to get file through password protected link with the password in form
+//    `GET /api/history` serves to list user state versions
+//    `GET /api/history/{version:string}` serves to get user data as of given version
+//    `POST /api/history/{version:string}/restore` serves to restore user data to given version
+//    `GET /api/usage` serves to get user storage usage and limits
+//    `POST /api/data/{namespace:string}` serves to update user state of given namespace
+//    `DELETE /api/data/{namespace:string}` serves to delete user data of given namespace
+//    `PATCH /api/data/{namespace:string}` serves to patch user data of given namespace
+//    `GET /api/namespaces` serves to list user data namespaces
+//    `GET /api/export` serves to export user data of all the namespaces and collections
+//    `POST /api/ops` serves to apply atomic operations to user data
+//    `POST /api/data/{namespace:string}/ops` serves to apply atomic operations to user data of given namespace
+//    `GET /api/events` serves to stream user state and files changes as Server-Sent Events
+//    `GET /api/events/ws` serves to stream user state and files changes over WebSocket
+//    `GET /api/sync` serves to get user data and files changes since the previous sync
+//    `POST /api/sync` serves to push user data changes made offline
+//    `POST /api/batch` serves to apply user data and file operations all-or-nothing
+//    `POST /api/collections/{name:string}` serves to create user record
+//    `GET /api/collections/{name:string}` serves to query user records
+//    `GET /api/collections/{name:string}/{id:string}` serves to get user record
+//    `PUT /api/collections/{name:string}/{id:string}` serves to replace user record
+//    `PATCH /api/collections/{name:string}/{id:string}` serves to update user record values
+//    `DELETE /api/collections/{name:string}/{id:string}` serves to delete user record
+//    `OPTIONS /api/uploads` serves to get tus resumable uploads capabilities
+//    `POST /api/uploads` serves to create resumable upload of user file
+//    `HEAD /api/uploads/{id:string}` serves to get offset of resumable upload
+//    `PATCH /api/uploads/{id:string}` serves to append chunk to resumable upload
+//    `DELETE /api/uploads/{id:string}` serves to terminate resumable upload
+//    `POST /graphql` serves to query and update account, user data and files with GraphQL,
+//                    if enabled with `GraphQL` setting
 //
 // Check BasicApp.Serve* functions for more details about specific handlers.
 //
@@ -37,6 +76,17 @@ func (app *BasicApp) Init() {
 	app.Iris.Get("/keepalive", app.RequireAuth(), app.ServeKeepAliveGet())
 	app.Iris.Delete("/account", app.RequireAuth(), app.ServeRemoveAccountDelete())
 
+	// public links
+	app.Iris.Get("/link/{id:string}", app.ServeLinkGet())
+	app.Iris.Post("/link/{id:string}", app.ServeLinkGet())
+
+	// event streams, which accept the token as a query parameter
+	app.Iris.Get("/api/events", app.TokenFromQuery(), app.RequireAuth(), app.ServeEventsGet())
+	app.Iris.Get("/api/events/ws", app.TokenFromQuery(), app.RequireAuth(), app.ServeEventsWebSocketGet())
+
+	// tus capabilities, which are discovered without the token
+	app.Iris.Options("/api/uploads", app.ServeUploadsOptions())
+
 	// api
 	api := app.Iris.Party("/api")
 	api.Use(app.RequireAuth())
@@ -44,13 +94,57 @@ func (app *BasicApp) Init() {
 		api.Post("/data", app.ServeDataPost())
 		api.Post("/file", app.ServeFilePost())
 		api.Get("/data", app.ServeDataGet())
+		api.Get("/data/{namespace:string}", app.ServeDataGet())
+		api.Get("/data/{namespace:string}/{path:string}", app.ServeDataPathGet())
 		api.Get("/file/{id:string}", app.ServeFileGet())
+		api.Head("/file/{id:string}", app.ServeFileGet())
+		api.Get("/files", app.ServeFilesGet())
 		api.Delete("/data", app.ServeDataDelete())
 		api.Delete("/file", app.ServeFileDelete())
+		api.Patch("/data", app.ServeDataPatch())
+		api.Post("/file/{id:string}/link", app.ServeFileLinkPost())
+		api.Get("/links", app.ServeLinksGet())
+		api.Delete("/link/{id:string}", app.ServeLinkDelete())
+		api.Get("/history", app.ServeHistoryGet())
+		api.Get("/history/{version:string}", app.ServeHistoryVersionGet())
+		api.Post("/history/{version:string}/restore", app.ServeHistoryRestorePost())
+		api.Get("/usage", app.ServeUsageGet())
+		api.Post("/data/{namespace:string}", app.ServeDataPost())
+		api.Delete("/data/{namespace:string}", app.ServeDataDelete())
+		api.Patch("/data/{namespace:string}", app.ServeDataPatch())
+		api.Get("/namespaces", app.ServeNamespacesGet())
+		api.Get("/export", app.ServeExportGet())
+		api.Post("/ops", app.ServeDataOpsPost())
+		api.Post("/data/{namespace:string}/ops", app.ServeDataOpsPost())
+		api.Get("/sync", app.ServeSyncGet())
+		api.Post("/sync", app.ServeSyncPost())
+		api.Post("/batch", app.ServeBatchPost())
+		api.Post("/collections/{name:string}", app.ServeCollectionPost())
+		api.Get("/collections/{name:string}", app.ServeCollectionGet())
+		api.Get("/collections/{name:string}/{id:string}", app.ServeRecordGet())
+		api.Put("/collections/{name:string}/{id:string}", app.ServeRecordPut())
+		api.Patch("/collections/{name:string}/{id:string}", app.ServeRecordPatch())
+		api.Delete("/collections/{name:string}/{id:string}", app.ServeRecordDelete())
+		api.Post("/uploads", app.ServeUploadsPost())
+		api.Head("/uploads/{id:string}", app.ServeUploadHead())
+		api.Patch("/uploads/{id:string}", app.ServeUploadPatch())
+		api.Delete("/uploads/{id:string}", app.ServeUploadDelete())
+	}
+
+	// graphql
+	if app.Settings.GraphQL {
+		app.Iris.Post("/graphql", app.RequireAuth(), app.ServeGraphQLPost())
 	}
 }
 
-// Start starts listening on given port.
+// Start starts listening on given port, along with removal of the expired user data keys,
+// stale resumable uploads and old sync tombstones, restoration of the files left by
+// unfinished batches, and retries of the failed webhook deliveries.
 func (app *BasicApp) Start(port string) {
+	go app.sweepExpiredLoop()
+	go app.pruneSyncLoop()
+	go app.sweepBatchFilesLoop()
+	go app.sweepUploadsLoop()
+	go app.deliverWebhooksLoop()
 	app.Iris.Run(iris.Addr(":" + port))
 }
@@ -0,0 +1,256 @@
+package basicserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+const defaultS3Region = "us-east-1"
+
+var errInvalidS3Settings = errors.New("Invalid S3 Settings")
+
+// S3Settings configure S3-compatible object storage, such as AWS S3 or MinIO:
+//
+//    `Endpoint` URL of the service, e.g. "https://s3.amazonaws.com" or "http://localhost:9000"
+//    `Region` region of the bucket ("us-east-1" by default)
+//    `Bucket` name of the bucket, which has to exist
+//    `AccessKey` and `SecretKey` credentials of the service
+//    `Prefix` prefix of the object keys, e.g. "files/"
+//
+// Buckets are addressed by path, e.g. "http://localhost:9000/{bucket}/{key}", which is
+// supported by MinIO as well.
+type S3Settings struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string
+}
+
+// s3Store keeps the content as objects of the bucket, named after their ids. Requests are
+// signed with AWS Signature Version 4, content itself is not signed.
+type s3Store struct {
+	settings S3Settings
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3Store returns store keeping the content in S3-compatible bucket.
+func NewS3Store(settings S3Settings) (FileStore, error) {
+	endpoint, err := url.Parse(settings.Endpoint)
+	if err != nil || endpoint.Host == "" || settings.Bucket == "" {
+		return nil, errInvalidS3Settings
+	}
+	if settings.Region == "" {
+		settings.Region = defaultS3Region
+	}
+	return &s3Store{settings: settings, endpoint: endpoint, client: &http.Client{}}, nil
+}
+
+func (store *s3Store) objectURL(id bson.ObjectId) string {
+	object := *store.endpoint
+	object.Path = strings.TrimSuffix(object.Path, "/") + "/" + store.settings.Bucket + "/" + store.settings.Prefix + id.Hex()
+	return object.String()
+}
+
+// Put uploads the content with single request. Content of unknown size is written to
+// temporary file first, since the request needs `Content-Length`.
+func (store *s3Store) Put(id bson.ObjectId, reader io.Reader, size int64) error {
+	if size < 0 {
+		temp, err := ioutil.TempFile("", "basicserver-s3")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(temp.Name())
+		defer temp.Close()
+		size, err = io.Copy(temp, reader)
+		if err == nil {
+			_, err = temp.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			return err
+		}
+		reader = temp
+	}
+
+	var body io.Reader
+	if size > 0 {
+		body = reader
+	}
+	request, err := http.NewRequest("PUT", store.objectURL(id), body)
+	if err != nil {
+		return err
+	}
+	request.ContentLength = size
+	response, err := store.do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+func (store *s3Store) Get(id bson.ObjectId, size int64) (FileContent, error) {
+	return &s3Object{store: store, id: id, size: size}, nil
+}
+
+func (store *s3Store) Delete(id bson.ObjectId) error {
+	request, err := http.NewRequest("DELETE", store.objectURL(id), nil)
+	if err != nil {
+		return err
+	}
+	response, err := store.do(request)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// do signs and sends the request. Missing object is mgo.ErrNotFound, the same as missing
+// GridFS file, other failures are errors with the response status.
+func (store *s3Store) do(request *http.Request) (*http.Response, error) {
+	store.sign(request, time.Now().UTC())
+	response, err := store.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response, nil
+	}
+	io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, mgo.ErrNotFound
+	}
+	return nil, fmt.Errorf("S3 %s %s", request.Method, response.Status)
+}
+
+// sign adds `Authorization` header of AWS Signature Version 4 to the request. Payload is
+// not signed, unless it's hash is set in `X-Amz-Content-Sha256` header already. `Range`
+// header is signed along with the others, if it's set.
+func (store *s3Store) sign(request *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payload := request.Header.Get("X-Amz-Content-Sha256")
+	if payload == "" {
+		payload = "UNSIGNED-PAYLOAD"
+		request.Header.Set("X-Amz-Content-Sha256", payload)
+	}
+	request.Header.Set("X-Amz-Date", amzDate)
+
+	// headers in alphabetical order
+	headers := []string{"host:" + request.URL.Host}
+	signedHeaders := "host"
+	if value := request.Header.Get("Range"); value != "" {
+		headers = append(headers, "range:"+strings.TrimSpace(value))
+		signedHeaders += ";range"
+	}
+	headers = append(headers, "x-amz-content-sha256:"+payload, "x-amz-date:"+amzDate)
+	signedHeaders += ";x-amz-content-sha256;x-amz-date"
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		strings.Join(headers, "\n") + "\n",
+		signedHeaders,
+		payload,
+	}, "\n")
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + store.settings.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+	key := []byte("AWS4" + store.settings.SecretKey)
+	for _, part := range []string{date, store.settings.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+store.settings.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, value string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// s3Object reads the object from the current offset. Seeking only moves the offset, the
+// object is requested from there once it's read.
+type s3Object struct {
+	store  *s3Store
+	id     bson.ObjectId
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (object *s3Object) Read(p []byte) (int, error) {
+	if object.offset >= object.size {
+		return 0, io.EOF
+	}
+	if object.body == nil {
+		request, err := http.NewRequest("GET", object.store.objectURL(object.id), nil)
+		if err != nil {
+			return 0, err
+		}
+		request.Header.Set("Range", "bytes="+strconv.FormatInt(object.offset, 10)+"-")
+		response, err := object.store.do(request)
+		if err != nil {
+			return 0, err
+		}
+		object.body = response.Body
+	}
+	n, err := object.body.Read(p)
+	object.offset += int64(n)
+	if err == io.EOF && object.offset < object.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (object *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += object.offset
+	case io.SeekEnd:
+		offset += object.size
+	}
+	if offset < 0 {
+		return object.offset, errors.New("Invalid Offset")
+	}
+	if offset != object.offset {
+		object.Close()
+		object.offset = offset
+	}
+	return offset, nil
+}
+
+func (object *s3Object) Close() error {
+	if object.body == nil {
+		return nil
+	}
+	err := object.body.Close()
+	object.body = nil
+	return err
+}
@@ -0,0 +1,216 @@
+package basicserver
+
+import (
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// SchemaError is returned when user data doesn't match the configured schema.
+type SchemaError struct {
+	Violations []SchemaViolation
+}
+
+// SchemaViolation describes single schema violation. `Path` is JSON Pointer to the
+// invalid value.
+type SchemaViolation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (err *SchemaError) Error() string {
+	messages := make([]string, len(err.Violations))
+	for i, violation := range err.Violations {
+		messages[i] = violation.Path + ": " + violation.Message
+	}
+	return "Schema Violation " + strings.Join(messages, ", ")
+}
+
+// stateSchemas holds compiled `Schema` and `KeySchemas` settings.
+type stateSchemas struct {
+	schema *jsonschema.Schema
+	keys   map[string]*jsonschema.Schema
+}
+
+// compileSchemas compiles schemas from the settings. It returns nil if there are none.
+func compileSchemas(settings *Settings) (*stateSchemas, error) {
+	if settings.Schema == "" && len(settings.KeySchemas) == 0 {
+		return nil, nil
+	}
+	schemas := &stateSchemas{keys: make(map[string]*jsonschema.Schema)}
+	var err error
+	if settings.Schema != "" {
+		schemas.schema, err = jsonschema.CompileString("state.json", settings.Schema)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for key, schema := range settings.KeySchemas {
+		schemas.keys[key], err = jsonschema.CompileString("state-"+key+".json", schema)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return schemas, nil
+}
+
+func (app *BasicApp) hasSchema() bool {
+	return app.schemas != nil
+}
+
+// validateState validates stored user data against the configured schemas. Key schemas
+// are checked only for the keys touched by the update.
+func (app *BasicApp) validateState(data bson.M, update bson.M) error {
+	document, err := normalizeJSON(data)
+	if err != nil {
+		return err
+	}
+
+	schemaError := &SchemaError{}
+	if app.schemas.schema != nil {
+		collectViolations(schemaError, "", app.schemas.schema.Validate(document))
+	}
+	for key := range touchedKeys(update, app.schemas.keys) {
+		value, ok := document[key]
+		if !ok {
+			continue
+		}
+		pointer := "/" + strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+		collectViolations(schemaError, pointer, app.schemas.keys[key].Validate(value))
+	}
+
+	if len(schemaError.Violations) > 0 {
+		return schemaError
+	}
+	return nil
+}
+
+// touchedKeys returns keys of `schemas` which are changed by the Mongo update.
+func touchedKeys(update bson.M, schemas map[string]*jsonschema.Schema) map[string]bool {
+	keys := make(map[string]bool)
+	for _, fields := range update {
+		fieldsMap, ok := fields.(bson.M)
+		if !ok {
+			continue
+		}
+		for field := range fieldsMap {
+			path, ok := splitDataPath(field)
+			if !ok {
+				continue
+			}
+			if len(path) == 0 {
+				for key := range schemas {
+					keys[key] = true
+				}
+				continue
+			}
+			if key := decodeKey(path[0]); schemas[key] != nil {
+				keys[key] = true
+			}
+		}
+	}
+	return keys
+}
+
+func collectViolations(schemaError *SchemaError, prefix string, err error) {
+	if err == nil {
+		return
+	}
+	validationError, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		schemaError.Violations = append(schemaError.Violations, SchemaViolation{
+			Path:    prefix,
+			Message: err.Error(),
+		})
+		return
+	}
+	if len(validationError.Causes) == 0 {
+		schemaError.Violations = append(schemaError.Violations, SchemaViolation{
+			Path:    prefix + validationError.InstanceLocation,
+			Message: validationError.Message,
+		})
+		return
+	}
+	for _, cause := range validationError.Causes {
+		collectViolations(schemaError, prefix, cause)
+	}
+}
+
+// SchemaVersions returns number of default namespace user states stored with each schema
+// version.
+// Version `0` stands for the states stored before the schema was configured.
+func (app *BasicApp) SchemaVersions() (map[int]int, error) {
+	var groups []struct {
+		Version int `bson:"_id"`
+		Count   int `bson:"count"`
+	}
+	err := app.Coll.States.Pipe([]bson.M{
+		{"$match": bson.M{"namespace": bson.M{"$exists": false}}},
+		{"$group": bson.M{
+			"_id":   bson.M{"$ifNull": []interface{}{"$schema_version", 0}},
+			"count": bson.M{"$sum": 1},
+		}},
+	}).All(&groups)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[int]int)
+	for _, group := range groups {
+		versions[group.Version] = group.Count
+	}
+	return versions, nil
+}
+
+// MigrateStates passes data of every default namespace user state stored with schema version older than
+// `SchemaVersion` setting through the migrate function. The result is validated against
+// the current schema and stored. States which change during the migration are skipped,
+// since every write stores them with the current schema version anyway.
+//
+// It returns number of migrated states.
+func (app *BasicApp) MigrateStates(migrate func(data map[string]interface{}) (map[string]interface{}, error)) (int, error) {
+	iter := app.Coll.States.Find(bson.M{
+		"namespace": bson.M{"$exists": false},
+		"$or": []bson.M{
+			{"schema_version": bson.M{"$lt": app.Settings.SchemaVersion}},
+			{"schema_version": bson.M{"$exists": false}},
+		},
+	}).Iter()
+
+	migrated := 0
+	for {
+		var state State
+		if !iter.Next(&state) {
+			break
+		}
+		err := app.openState(&state, nil)
+		if err != nil {
+			iter.Close()
+			return migrated, err
+		}
+		data, err := normalizeJSON(state.Data)
+		if err != nil {
+			iter.Close()
+			return migrated, err
+		}
+		data, err = migrate(data)
+		if err != nil {
+			iter.Close()
+			return migrated, err
+		}
+
+		update := bson.M{"$set": bson.M{"data": encodeKeys(data)}}
+		condition := &stateCondition{versions: []int64{state.Version}}
+		_, err = app.updateState(state.ID, "", update, condition, "schema-migration")
+		if err == errPreconditionFailed {
+			continue
+		}
+		if err != nil {
+			iter.Close()
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, iter.Close()
+}
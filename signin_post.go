@@ -93,6 +93,7 @@ func (app *BasicApp) ServeSigninPost() iris.Handler {
 		app.Coll.Users.UpdateId(user.ID, bson.M{
 			"$set": bson.M{"last_login_at": timeNow},
 		})
+		app.publishAccountEvent(user.ID, "signin", user.Email)
 
 		ctx.JSON(iris.Map{
 			"expires": expiresAt,
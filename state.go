@@ -1,13 +1,302 @@
 package basicserver
 
-import "github.com/globalsign/mgo/bson"
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+const maxStateAttempts = 3
+
+var errPreconditionFailed = errors.New("Precondition Failed")
 
 // State is an user's state data entity:
 //
-//    `ID` user uid
+//    `ID` user uid for the default namespace, generated id for the others
+//    `UID` user uid for namespaces other than default
+//    `Namespace` data namespace, empty for the default one
 //    `Data` user data
+//    `Encrypted` user data encrypted with the user data key, which is stored instead of `Data`
+//    `Version` version increased with every write, exposed as `ETag` header
+//    `UpdatedAt` time at which last write happened
+//    `SchemaVersion` version of the schema against which the data was validated
+//    `Sync` times of the default namespace changes used by sync
+//    `Expires` times at which the top level keys written with time-to-live expire
 //
 type State struct {
-	ID   bson.ObjectId `bson:"_id" json:"id"`
-	Data bson.M        `bson:"data"`
+	ID            bson.ObjectId        `bson:"_id" json:"id"`
+	UID           bson.ObjectId        `bson:"uid,omitempty" json:"-"`
+	Namespace     string               `bson:"namespace,omitempty" json:"-"`
+	Data          bson.M               `bson:"data"`
+	Encrypted     []byte               `bson:"encrypted,omitempty" json:"-"`
+	Version       int64                `bson:"version" json:"version"`
+	UpdatedAt     time.Time            `bson:"updated_at" json:"updated_at"`
+	SchemaVersion int                  `bson:"schema_version,omitempty" json:"-"`
+	Sync          *stateSync           `bson:"sync,omitempty" json:"-"`
+	Expires       map[string]time.Time `bson:"expires,omitempty" json:"-"`
+}
+
+// ETag returns `ETag` header value of the state version.
+func (state *State) ETag() string {
+	return `"` + strconv.FormatInt(state.Version, 10) + `"`
+}
+
+// stateCondition restricts state write to the given versions. Version `0` stands for
+// the state which doesn't exist yet.
+type stateCondition struct {
+	versions []int64
+	exists   bool
+}
+
+// matches reports whether the state in given version satisfies the condition.
+func (condition *stateCondition) matches(version int64) bool {
+	if condition.exists {
+		return version > 0
+	}
+	for _, v := range condition.versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStateCondition parses `If-Match` or `If-None-Match` header value. It returns nil if the
+// header is empty. Unparseable ETags never match. Weak ETags match only with weak comparison,
+// which is used for `If-None-Match` (RFC 9110), while `If-Match` requires strong comparison.
+func parseStateCondition(header string, weak bool) *stateCondition {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return nil
+	}
+	if header == "*" {
+		return &stateCondition{exists: true}
+	}
+	condition := &stateCondition{}
+	for _, etag := range strings.Split(header, ",") {
+		etag = strings.TrimSpace(etag)
+		if weak {
+			etag = strings.TrimPrefix(etag, "W/")
+		}
+		if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+			continue
+		}
+		version, err := strconv.ParseInt(etag[1:len(etag)-1], 10, 64)
+		if err == nil {
+			condition.versions = append(condition.versions, version)
+		}
+	}
+	return condition
+}
+
+// writeState applies Mongo update to the user state with `updateState` and sets `ETag`
+// header with the new state version.
+func (app *BasicApp) writeState(ctx iris.Context, objectUID bson.ObjectId, namespace string, update bson.M, condition *stateCondition) (*State, error) {
+	state, err := app.updateState(objectUID, namespace, update, condition, requestID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	ctx.Header("ETag", state.ETag())
+	return state, nil
+}
+
+// updateState applies Mongo update to the user state in given namespace and increases it's
+// version. Empty namespace stands for the default one. If the condition is given, the
+// update happens only if state version matches it, otherwise `errPreconditionFailed` is
+// returned.
+//
+// If state schema is configured, the default namespace state after the update is validated
+// first and `*SchemaError` is returned in case of violations. If state size is limited by
+// the user quota, `errStateTooLarge` is returned for the state which would exceed it.
+//
+// Encrypted states can't be updated by Mongo, so the whole user data after the update is
+// encrypted and stored instead.
+//
+// Expired keys which the update changes nested paths of are removed first.
+func (app *BasicApp) updateState(objectUID bson.ObjectId, namespace string, update bson.M, condition *stateCondition, requestID string) (*State, error) {
+	return app.updateStateIn(nil, objectUID, namespace, update, condition, requestID)
+}
+
+// updateStateIn applies the update the same as `updateState`, within the transaction.
+// History and events of the update are recorded once the transaction is committed.
+func (app *BasicApp) updateStateIn(tx *mongoTransaction, objectUID bson.ObjectId, namespace string, update bson.M, condition *stateCondition, requestID string) (*State, error) {
+	quota, err := app.userQuota(objectUID)
+	if err != nil {
+		return nil, err
+	}
+	condition, err = app.dropExpiredKeys(objectUID, namespace, update, condition)
+	if err != nil {
+		return nil, err
+	}
+	validate := app.hasSchema() && namespace == ""
+	// states might be encrypted, even if the encryption is disabled now
+	encryption := len(app.Settings.EncryptionKeys) > 0
+
+	for attempt := 1; ; attempt++ {
+		writeCondition := condition
+		var storedData bson.M
+		if validate || quota.StateBytes > 0 || encryption {
+			// checked state needs to be the one which is updated
+			var current State
+			err := app.Coll.States.Find(stateSelector(objectUID, namespace)).One(&current)
+			if err != nil && err.Error() != "not found" {
+				return nil, err
+			}
+			if condition != nil && !condition.matches(current.Version) {
+				return nil, errPreconditionFailed
+			}
+			err = app.openState(&current, nil)
+			if err != nil {
+				return nil, err
+			}
+			data, err := applyUpdate(current.Data, update)
+			if err != nil {
+				return nil, err
+			}
+			if validate {
+				err = app.validateState(data, update)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if quota.StateBytes > 0 {
+				size, err := stateSize(data)
+				if err != nil {
+					return nil, err
+				}
+				if size > quota.StateBytes {
+					return nil, errStateTooLarge
+				}
+			}
+			writeCondition = &stateCondition{versions: []int64{current.Version}}
+			if app.encryptionEnabled() || current.Encrypted != nil {
+				storedData = data
+			}
+		}
+
+		state, err := app.applyStateUpdate(tx, objectUID, namespace, update, writeCondition, requestID, validate, storedData)
+		if err == errPreconditionFailed && condition == nil && attempt < maxStateAttempts {
+			continue
+		}
+		return state, err
+	}
+}
+
+// applyStateUpdate writes the update to the state. If the stored data is given, it's stored
+// as a whole instead of the user data paths of the update.
+func (app *BasicApp) applyStateUpdate(tx *mongoTransaction, objectUID bson.ObjectId, namespace string, update bson.M, condition *stateCondition, requestID string, validated bool, storedData bson.M) (*State, error) {
+	selector := stateSelector(objectUID, namespace)
+	upsert := true
+	if condition != nil {
+		if condition.exists {
+			upsert = false
+		} else {
+			var versions []interface{}
+			upsert = false
+			for _, version := range condition.versions {
+				if version == 0 {
+					// state might not be existing yet or have no version
+					versions = append(versions, nil)
+					upsert = true
+				}
+				versions = append(versions, version)
+			}
+			selector["version"] = bson.M{"$in": versions}
+		}
+	}
+
+	setInput, ok := update["$set"].(bson.M)
+	if !ok {
+		setInput = bson.M{}
+		update["$set"] = setInput
+	}
+	setInput["updated_at"] = time.Now()
+	if validated {
+		setInput["schema_version"] = app.Settings.SchemaVersion
+	}
+	incInput, ok := update["$inc"].(bson.M)
+	if !ok {
+		incInput = bson.M{}
+		update["$inc"] = incInput
+	}
+	incInput["version"] = 1
+	addExpiresUpdate(update)
+	if namespace == "" {
+		addSyncUpdate(update)
+	}
+	mongoUpdate := update
+	if storedData != nil {
+		var err error
+		mongoUpdate, err = app.storedStateUpdate(objectUID, namespace, update, storedData)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var state State
+	change := mgo.Change{
+		Update:    mongoUpdate,
+		Upsert:    upsert,
+		ReturnNew: true,
+	}
+	var err error
+	if tx != nil {
+		err = tx.apply(app.Coll.States, selector, change, &state)
+	} else {
+		_, err = app.Coll.States.Find(selector).Apply(change, &state)
+	}
+	if err != nil {
+		if err == mgo.ErrNotFound || mgo.IsDup(err) {
+			return nil, errPreconditionFailed
+		}
+		if isTooLargeError(err) {
+			return nil, errStateTooLarge
+		}
+		if isInvalidUpdateError(err) {
+			return nil, errUnsupportedUpdate
+		}
+		return nil, err
+	}
+	err = app.openState(&state, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.afterCommit(func() {
+		err := app.recordHistory(requestID, update, &state)
+		if err != nil { // the write itself succeeded
+			app.Iris.Logger().Error(err)
+		}
+		app.publishStateEvent(objectUID, namespace, update, &state)
+	})
+
+	return &state, nil
+}
+
+// handleStateError handles error returned by state write.
+func (app *BasicApp) handleStateError(err error, ctx iris.Context) {
+	switch e := err.(type) {
+	case *SchemaError:
+		app.HandleError(err, ctx, iris.StatusUnprocessableEntity)
+		ctx.JSON(iris.Map{"errors": e.Violations})
+		return
+	}
+	switch err {
+	case errPreconditionFailed:
+		app.HandleError(err, ctx, iris.StatusPreconditionFailed)
+		ctx.WriteString("Precondition Failed")
+	case errUnsupportedUpdate:
+		app.HandleError(err, ctx, iris.StatusBadRequest)
+		ctx.WriteString(err.Error())
+	case errStateTooLarge:
+		app.HandleError(err, ctx, iris.StatusRequestEntityTooLarge)
+		ctx.WriteString(err.Error())
+	default:
+		app.HandleError(err, ctx, iris.StatusInternalServerError)
+	}
 }
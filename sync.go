@@ -0,0 +1,238 @@
+package basicserver
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+const (
+	defaultSyncTombstoneMaxAge = 30 * 24 * time.Hour
+	syncPruneInterval          = time.Hour
+)
+
+var (
+	errInvalidSyncCursor = errors.New("Invalid Sync Cursor")
+	errInvalidSyncChange = errors.New("Invalid Sync Change")
+)
+
+// syncTimestamp makes Mongo set the field to the time of the write. Timestamps of the
+// writes to the same document always increase, so they are used as sync cursors.
+var syncTimestamp = bson.M{"$type": "timestamp"}
+
+// stateSync holds times of the last changes of the default namespace user data keys and
+// user files, along with tombstones of the removed ones. Keys and file names are escaped.
+//
+//    `At` time of the last change
+//    `Reset` time at which the whole user data was replaced
+//    `Keys` times of the last changes of user data keys
+//    `Deleted` times at which user data keys were removed
+//    `Files` times of the last uploads of user files
+//    `DeletedFiles` times at which user files were removed
+//    `Pruned` time of the newest pruned tombstone, older cursors need the whole user data
+//
+type stateSync struct {
+	At           bson.MongoTimestamp            `bson:"at"`
+	Reset        bson.MongoTimestamp            `bson:"reset,omitempty"`
+	Keys         map[string]bson.MongoTimestamp `bson:"keys,omitempty"`
+	Deleted      map[string]bson.MongoTimestamp `bson:"deleted,omitempty"`
+	Files        map[string]bson.MongoTimestamp `bson:"files,omitempty"`
+	DeletedFiles map[string]bson.MongoTimestamp `bson:"deleted_files,omitempty"`
+	Pruned       bson.MongoTimestamp            `bson:"pruned,omitempty"`
+}
+
+// syncChange is a change of the user data key made by offline client:
+//
+//    `Key` top level key of the user data
+//    `Value` new value of the key
+//    `Deleted` whether the key was removed
+//    `UpdatedAt` time at which the change was made on the client
+//
+type syncChange struct {
+	Key       string      `json:"key"`
+	Value     interface{} `json:"value,omitempty"`
+	Deleted   bool        `json:"deleted,omitempty"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// lastChange returns sync timestamp of the last server change of the escaped key.
+// Replacement of the whole user data changes all the keys.
+func (sync *stateSync) lastChange(key string) bson.MongoTimestamp {
+	timestamp := sync.Reset
+	for _, times := range []map[string]bson.MongoTimestamp{sync.Keys, sync.Deleted} {
+		if times[key] > timestamp {
+			timestamp = times[key]
+		}
+	}
+	return timestamp
+}
+
+// addSyncUpdate extends Mongo update of the user data with the sync times of the changed keys.
+// Removal of the whole key leaves a tombstone, any other change marks the key as changed.
+func addSyncUpdate(update bson.M) {
+	reset := false
+	keys := make(map[string]bool) // whether the key is removed
+	for operator, fields := range update {
+		fieldsMap, ok := fields.(bson.M)
+		if !ok {
+			continue
+		}
+		for field := range fieldsMap {
+			path, ok := splitDataPath(field)
+			if !ok {
+				continue
+			}
+			if len(path) == 0 {
+				reset = true
+				continue
+			}
+			removed := operator == "$unset" && len(path) == 1
+			if previous, ok := keys[path[0]]; ok {
+				removed = removed && previous
+			}
+			keys[path[0]] = removed
+		}
+	}
+
+	currentDate := bson.M{"sync.at": syncTimestamp}
+	unset := bson.M{}
+	if reset {
+		currentDate["sync.reset"] = syncTimestamp
+	}
+	for key, removed := range keys {
+		if removed {
+			currentDate["sync.deleted."+key] = syncTimestamp
+			unset["sync.keys."+key] = ""
+		} else {
+			currentDate["sync.keys."+key] = syncTimestamp
+			unset["sync.deleted."+key] = ""
+		}
+	}
+	addUpdateFields(update, "$currentDate", currentDate)
+	addUpdateFields(update, "$unset", unset)
+}
+
+// addUpdateFields adds fields to the operator of Mongo update.
+func addUpdateFields(update bson.M, operator string, fields bson.M) {
+	if len(fields) == 0 {
+		return
+	}
+	operatorFields, ok := update[operator].(bson.M)
+	if !ok {
+		operatorFields = bson.M{}
+		update[operator] = operatorFields
+	}
+	for field, value := range fields {
+		operatorFields[field] = value
+	}
+}
+
+// recordFileSync stores sync time of the user file upload or removal.
+func (app *BasicApp) recordFileSync(objectUID bson.ObjectId, fileName string, removed bool) error {
+	key := encodeKey(fileName)
+	currentDate := bson.M{"sync.at": syncTimestamp}
+	unset := bson.M{}
+	if removed {
+		currentDate["sync.deleted_files."+key] = syncTimestamp
+		unset["sync.files."+key] = ""
+	} else {
+		currentDate["sync.files."+key] = syncTimestamp
+		unset["sync.deleted_files."+key] = ""
+	}
+	_, err := app.Coll.States.UpsertId(objectUID, bson.M{
+		"$currentDate": currentDate,
+		"$unset":       unset,
+	})
+	return err
+}
+
+// PruneSyncTombstones removes tombstones of the user data keys and user files removed before
+// given time. Sync cursors older than the newest pruned tombstone of the state get the whole
+// user data, since the removals can't be listed anymore. States which change meanwhile are
+// left for the next run.
+//
+// It returns number of states from which the tombstones were removed.
+func (app *BasicApp) PruneSyncTombstones(before time.Time) (int, error) {
+	cutoff := bson.MongoTimestamp(before.Unix() << 32)
+	iter := app.Coll.States.Find(bson.M{"$or": []bson.M{
+		{"sync.deleted": bson.M{"$exists": true}},
+		{"sync.deleted_files": bson.M{"$exists": true}},
+	}}).Select(bson.M{"sync": 1}).Iter()
+
+	pruned := 0
+	var state State
+	for iter.Next(&state) {
+		unset := bson.M{}
+		var newest bson.MongoTimestamp
+		for field, times := range map[string]map[string]bson.MongoTimestamp{
+			"sync.deleted.":       state.Sync.Deleted,
+			"sync.deleted_files.": state.Sync.DeletedFiles,
+		} {
+			for key, timestamp := range times {
+				if timestamp < cutoff {
+					unset[field+key] = ""
+					if timestamp > newest {
+						newest = timestamp
+					}
+				}
+			}
+		}
+		if len(unset) > 0 {
+			err := app.Coll.States.Update(bson.M{"_id": state.ID, "sync.at": state.Sync.At}, bson.M{
+				"$unset": unset,
+				"$max":   bson.M{"sync.pruned": newest},
+			})
+			if err == nil {
+				pruned++
+			} else if err != mgo.ErrNotFound {
+				iter.Close()
+				return pruned, err
+			}
+		}
+		state = State{}
+	}
+	return pruned, iter.Close()
+}
+
+// pruneSyncLoop runs PruneSyncTombstones every hour for the tombstones older than
+// `SyncTombstoneMaxAge`.
+func (app *BasicApp) pruneSyncLoop() {
+	maxAge := app.Settings.SyncTombstoneMaxAge
+	if maxAge <= 0 {
+		maxAge = defaultSyncTombstoneMaxAge
+	}
+	for range time.Tick(syncPruneInterval) {
+		_, err := app.PruneSyncTombstones(time.Now().Add(-maxAge))
+		if err != nil {
+			app.Iris.Logger().Error(err)
+		}
+	}
+}
+
+// formatSyncCursor returns sync cursor of the time.
+func formatSyncCursor(timestamp bson.MongoTimestamp) string {
+	return strconv.FormatInt(int64(timestamp), 10)
+}
+
+// parseSyncCursor parses sync cursor. Empty cursor stands for no previous sync.
+func parseSyncCursor(cursor string) (bson.MongoTimestamp, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	timestamp, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || timestamp < 0 {
+		return 0, errInvalidSyncCursor
+	}
+	return bson.MongoTimestamp(timestamp), nil
+}
+
+// syncTime returns wall clock time of the sync timestamp.
+func syncTime(timestamp bson.MongoTimestamp) time.Time {
+	if timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(timestamp)>>32, 0)
+}
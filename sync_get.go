@@ -0,0 +1,148 @@
+package basicserver
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeSyncGet serves
+// Method:   GET
+// Resource: http://localhost/api/sync?since={cursor}
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Authorization: Bearer {token}
+//
+// Returns changes of the default namespace user data and user files made after the cursor
+// returned by the previous sync. Keys are the top level keys of the user data.
+//
+// If everything goes well, then this will return status code `200` and `application/json`
+// response with the changed keys along with their values, removed keys, uploaded and
+// removed files and the cursor to be passed to the next sync:
+//
+//    {
+//      "reset": false,
+//      "data": {
+//        "profile": { "name": "Nick" }
+//      },
+//      "deleted": [ "draft" ],
+//      "files": [ "avatar.png" ],
+//      "deleted_files": [],
+//      "cursor": "6672081049958613001"
+//    }
+//
+// Without `since` cursor, if the whole user data was replaced after it, or if it's older
+// than the kept tombstones of the removed keys and files (`SyncTombstoneMaxAge`), `reset`
+// is true and the response holds all the user data and all the user files, so the client
+// should drop its local copy.
+//
+// In case of malformed cursor, this will return status code `400` and `text/plain` error
+// message as a response.
+//
+// In case of error, this will return status code `500` and `text/plain` error
+// message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeSyncGet() iris.Handler {
+	return func(ctx iris.Context) {
+		uid := ctx.Values().Get("uid").(string)
+		objectUID := bson.ObjectIdHex(uid)
+
+		since, err := parseSyncCursor(ctx.URLParam("since"))
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		var state State
+		err = app.Coll.States.FindId(objectUID).One(&state)
+		if err == nil {
+			err = app.openState(&state, nil)
+		}
+		if err != nil && err.Error() != "not found" {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+		sync := stateSync{}
+		if state.Sync != nil {
+			sync = *state.Sync
+		}
+
+		data := bson.M{}
+		deleted := []string{}
+		files := []string{}
+		deletedFiles := []string{}
+		// cursor ahead of the state is left from the removed account
+		reset := since == 0 || since < sync.Reset || since < sync.Pruned || since > sync.At
+		if reset {
+			for key, value := range state.Data {
+				data[decodeKey(key)] = decodeKeys(value)
+			}
+			files, err = app.userFileNames(uid)
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+				return
+			}
+		} else {
+			for key, timestamp := range sync.Keys {
+				if value, ok := state.Data[key]; ok && timestamp > since {
+					data[decodeKey(key)] = decodeKeys(value)
+				}
+			}
+			deleted = changedSyncKeys(sync.Deleted, since)
+			files = changedSyncKeys(sync.Files, since)
+			deletedFiles = changedSyncKeys(sync.DeletedFiles, since)
+		}
+
+		ctx.JSON(iris.Map{
+			"reset":         reset,
+			"data":          data,
+			"deleted":       deleted,
+			"files":         files,
+			"deleted_files": deletedFiles,
+			"cursor":        formatSyncCursor(sync.At),
+		})
+	}
+}
+
+// changedSyncKeys returns sorted unescaped keys changed after the cursor.
+func changedSyncKeys(times map[string]bson.MongoTimestamp, since bson.MongoTimestamp) []string {
+	keys := []string{}
+	for key, timestamp := range times {
+		if timestamp > since {
+			keys = append(keys, decodeKey(key))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// userFileNames returns sorted names of all the user files.
+func (app *BasicApp) userFileNames(uid string) ([]string, error) {
+	var files []struct {
+		Filename string `bson:"filename"`
+	}
+	err := app.Coll.Files.Files.Find(bson.M{"filename": userFilesRange(uid)}).
+		Select(bson.M{"filename": 1}).
+		All(&files)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, file := range files {
+		name := strings.TrimPrefix(file.Filename, uid+":")
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
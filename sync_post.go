@@ -0,0 +1,188 @@
+package basicserver
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeSyncPost serves
+// Method:   POST
+// Resource: http://localhost/api/sync
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//		Content-Type: application/json
+//		Authorization: Bearer {token}
+//
+// Pushes changes of the default namespace user data made by offline client. Sample request
+// to be `POST`ed as `application/json`:
+//
+//    {
+//      "since": "6671563618107719681",
+//      "changes": [
+//        { "key": "profile", "value": { "name": "Nick" }, "updated_at": "2019-03-19T10:00:00Z" },
+//        { "key": "draft", "deleted": true, "updated_at": "2019-03-19T10:05:00Z" }
+//      ]
+//    }
+//
+// Keys are the top level keys of the user data. `since` is the cursor of the last GET
+// /api/sync the changes are based on. Keys which the server didn't change after the cursor
+// are applied, since the cursor is compared with the exact time of the server changes.
+// Changes of the keys changed by the server meanwhile, or without the cursor, are resolved
+// by time and the last write wins: the change is applied only if it was made after the
+// last server change of the key, otherwise it's returned as a conflict along with the
+// server value. Server times are stored in whole seconds, so the server wins the changes
+// made within the same second. Of the several changes of the same key only the last one
+// is considered.
+//
+// If everything goes well, then this will return status code `200`, `ETag` header with
+// the state version and `application/json` response with the applied keys and conflicts:
+//
+//    {
+//      "applied": [ "draft" ],
+//      "conflicts": [
+//        {
+//          "key": "profile",
+//          "value": { "name": "Nicholas" },
+//          "updated_at": "2019-03-19T10:30:00Z"
+//        }
+//      ]
+//    }
+//
+// In case of change without the key or the time, or invalid cursor, this will return
+// status code `400` and `text/plain` error message as a response.
+//
+// In case of error, this will return status code `400`, `413`, `422` or `500`
+// and `text/plain` error message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeSyncPost() iris.Handler {
+	return func(ctx iris.Context) {
+		var input struct {
+			Since   string       `json:"since"`
+			Changes []syncChange `json:"changes"`
+		}
+		err := ctx.ReadJSON(&input)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			return
+		}
+		since, err := parseSyncCursor(input.Since)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		uid := ctx.Values().Get("uid").(string)
+		objectUID := bson.ObjectIdHex(uid)
+
+		err = validateKeysCount(len(input.Changes))
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		var keys []string
+		changes := make(map[string]syncChange)
+		for _, change := range input.Changes {
+			if change.Key == "" || change.UpdatedAt.IsZero() {
+				app.HandleError(errInvalidSyncChange, ctx, iris.StatusBadRequest)
+				ctx.WriteString(errInvalidSyncChange.Error())
+				return
+			}
+			err = validateKey(change.Key)
+			if err == nil {
+				err = validateDataValue(change.Value, 1)
+			}
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusBadRequest)
+				ctx.WriteString(err.Error())
+				return
+			}
+			if _, ok := changes[change.Key]; !ok {
+				keys = append(keys, change.Key)
+			}
+			changes[change.Key] = change
+		}
+
+		for attempt := 1; ; attempt++ {
+			var current State
+			err = app.Coll.States.FindId(objectUID).One(&current)
+			if err == nil {
+				err = app.openState(&current, nil)
+			}
+			if err != nil && err.Error() != "not found" {
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+				return
+			}
+			sync := stateSync{}
+			if current.Sync != nil {
+				sync = *current.Sync
+			}
+			base := since
+			if base < sync.Pruned { // deletions after the cursor might be pruned
+				base = 0
+			}
+
+			applied := []string{}
+			conflicts := []syncChange{}
+			set := bson.M{}
+			unset := bson.M{}
+			for _, key := range keys {
+				change := changes[key]
+				encodedKey := encodeKey(key)
+				changedAt := sync.lastChange(encodedKey)
+				if changedAt > base && !change.UpdatedAt.Truncate(time.Second).After(syncTime(changedAt)) {
+					value, ok := current.Data[encodedKey]
+					conflicts = append(conflicts, syncChange{
+						Key:       key,
+						Value:     decodeKeys(value),
+						Deleted:   !ok,
+						UpdatedAt: syncTime(changedAt),
+					})
+					continue
+				}
+				if change.Deleted {
+					unset[dataPath([]string{key})] = ""
+				} else {
+					set[dataPath([]string{key})] = encodeKeys(change.Value)
+				}
+				applied = append(applied, key)
+			}
+
+			if len(applied) == 0 {
+				ctx.Header("ETag", current.ETag())
+			} else {
+				update := bson.M{}
+				if len(set) > 0 {
+					update["$set"] = set
+				}
+				if len(unset) > 0 {
+					update["$unset"] = unset
+				}
+				// conflicts are checked against the state which is updated
+				condition := &stateCondition{versions: []int64{current.Version}}
+				_, err = app.writeState(ctx, objectUID, "", update, condition)
+				if err == errPreconditionFailed && attempt < maxStateAttempts {
+					continue
+				}
+				if err != nil {
+					app.handleStateError(err, ctx)
+					return
+				}
+			}
+
+			ctx.JSON(iris.Map{
+				"applied":   applied,
+				"conflicts": conflicts,
+			})
+			return
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package basicserver
+
+import (
+	"crypto/rand"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+const (
+	// transactionsWireVersion is the wire version of MongoDB 4.0, the first one with
+	// multi-document transactions.
+	transactionsWireVersion = 7
+	writeConflictCode       = 112
+	noSuchTransactionCode   = 251
+)
+
+// mongoTransaction is a multi-document transaction of Mongo logical session. The driver
+// doesn't support sessions, so the commands are run with the session fields added.
+// Transactions are available on replica sets only.
+//
+// Work which depends on the transaction outcome, like history or events of the written
+// state, is deferred with `afterCommit`. Nil transaction stands for no transaction, in
+// which case the writes are applied at once.
+type mongoTransaction struct {
+	db        *mgo.Database
+	lsid      bson.M
+	started   bool
+	callbacks []func()
+}
+
+// transactionsSupported reports whether the Mongo server is a replica set member which
+// runs multi-document transactions.
+func transactionsSupported(db *mgo.Database) bool {
+	var result struct {
+		SetName        string `bson:"setName"`
+		MaxWireVersion int    `bson:"maxWireVersion"`
+	}
+	err := db.Run("isMaster", &result)
+	return err == nil && result.SetName != "" && result.MaxWireVersion >= transactionsWireVersion
+}
+
+// startTransaction returns new transaction, or nil if the server doesn't run them.
+func (app *BasicApp) startTransaction() (*mongoTransaction, error) {
+	if !app.transactions {
+		return nil, nil
+	}
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+	id[6] = id[6]&0x0f | 0x40 // UUID version 4
+	id[8] = id[8]&0x3f | 0x80
+	return &mongoTransaction{
+		db:   app.Db.With(app.Db.Session.Copy()),
+		lsid: bson.M{"id": bson.Binary{Kind: 0x04, Data: id}},
+	}, nil
+}
+
+// run runs the command in the transaction.
+func (tx *mongoTransaction) run(command bson.D, result interface{}) error {
+	command = append(command,
+		bson.DocElem{Name: "lsid", Value: tx.lsid},
+		bson.DocElem{Name: "txnNumber", Value: int64(1)},
+		bson.DocElem{Name: "autocommit", Value: false},
+	)
+	if !tx.started {
+		command = append(command, bson.DocElem{Name: "startTransaction", Value: true})
+		tx.started = true
+	}
+	err := tx.db.Run(command, result)
+	if queryErr, ok := err.(*mgo.QueryError); ok && queryErr.Code == writeConflictCode {
+		// concurrent write fails the transaction the same as outdated state version
+		return errPreconditionFailed
+	}
+	return err
+}
+
+// update updates single document of the collection, the same as Collection.Update.
+func (tx *mongoTransaction) update(collection *mgo.Collection, selector bson.M, update bson.M) error {
+	var result struct {
+		N           int `bson:"n"`
+		WriteErrors []struct {
+			Code   int    `bson:"code"`
+			ErrMsg string `bson:"errmsg"`
+		} `bson:"writeErrors"`
+	}
+	err := tx.run(bson.D{
+		{Name: "update", Value: collection.Name},
+		{Name: "updates", Value: []bson.M{{"q": selector, "u": update}}},
+	}, &result)
+	if err != nil {
+		return err
+	}
+	if len(result.WriteErrors) > 0 {
+		return &mgo.LastError{Code: result.WriteErrors[0].Code, Err: result.WriteErrors[0].ErrMsg}
+	}
+	if result.N == 0 {
+		return mgo.ErrNotFound
+	}
+	return nil
+}
+
+// apply runs the change on single document of the collection, the same as Query.Apply.
+func (tx *mongoTransaction) apply(collection *mgo.Collection, selector bson.M, change mgo.Change, result interface{}) error {
+	var response struct {
+		Value bson.Raw `bson:"value"`
+	}
+	err := tx.run(bson.D{
+		{Name: "findAndModify", Value: collection.Name},
+		{Name: "query", Value: selector},
+		{Name: "update", Value: change.Update},
+		{Name: "upsert", Value: change.Upsert},
+		{Name: "new", Value: change.ReturnNew},
+	}, &response)
+	if err != nil {
+		return err
+	}
+	if response.Value.Kind != 0x03 { // null if nothing matched
+		return mgo.ErrNotFound
+	}
+	return response.Value.Unmarshal(result)
+}
+
+// afterCommit runs the function once the transaction is committed, or at once if there is
+// no transaction.
+func (tx *mongoTransaction) afterCommit(callback func()) {
+	if tx == nil {
+		callback()
+		return
+	}
+	tx.callbacks = append(tx.callbacks, callback)
+}
+
+// commit commits the transaction and runs the deferred work.
+func (tx *mongoTransaction) commit() error {
+	if tx == nil {
+		return nil
+	}
+	if tx.started {
+		err := tx.finish("commitTransaction")
+		if err != nil {
+			return err
+		}
+	}
+	for _, callback := range tx.callbacks {
+		callback()
+	}
+	tx.callbacks = nil
+	return nil
+}
+
+// abort aborts the transaction, none of it's writes are applied.
+func (tx *mongoTransaction) abort() error {
+	if tx == nil || !tx.started {
+		return nil
+	}
+	tx.callbacks = nil
+	err := tx.finish("abortTransaction")
+	if queryErr, ok := err.(*mgo.QueryError); ok && queryErr.Code == noSuchTransactionCode {
+		return nil // aborted by the server already, e.g. after failed command
+	}
+	return err
+}
+
+func (tx *mongoTransaction) finish(command string) error {
+	tx.started = false
+	return tx.db.Session.Run(bson.D{
+		{Name: command, Value: 1},
+		{Name: "lsid", Value: tx.lsid},
+		{Name: "txnNumber", Value: int64(1)},
+		{Name: "autocommit", Value: false},
+	}, nil)
+}
+
+// close ends the session of the transaction.
+func (tx *mongoTransaction) close() {
+	if tx == nil {
+		return
+	}
+	tx.db.Session.Run(bson.D{{Name: "endSessions", Value: []bson.M{tx.lsid}}}, nil)
+	tx.db.Session.Close()
+}
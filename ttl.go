@@ -0,0 +1,223 @@
+package basicserver
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+const defaultTTLSweepInterval = time.Minute
+
+var errUnsupportedTTL = errors.New("Unsupported TTL")
+
+// parseTTL parses comma separated `key=seconds` pairs of time-to-live of the top level
+// user data keys. Keys are URL escaped, e.g. `draft=600,temp%2Ctoken=60`.
+func parseTTL(value string) (map[string]time.Duration, error) {
+	ttl := make(map[string]time.Duration)
+	for _, pair := range strings.Split(value, ",") {
+		i := strings.LastIndex(pair, "=")
+		if i < 0 {
+			return nil, errUnsupportedTTL
+		}
+		key, err := url.QueryUnescape(strings.TrimSpace(pair[:i]))
+		if err != nil || validateKey(key) != nil {
+			return nil, errUnsupportedTTL
+		}
+		seconds, err := strconv.ParseInt(strings.TrimSpace(pair[i+1:]), 10, 64)
+		if err != nil || seconds <= 0 {
+			return nil, errUnsupportedTTL
+		}
+		ttl[key] = time.Duration(seconds) * time.Second
+	}
+	return ttl, validateKeysCount(len(ttl))
+}
+
+// formatTTL returns remaining time-to-live of the keys present in the data, in the same
+// format as parseTTL accepts.
+func formatTTL(expires map[string]time.Time, data bson.M, now time.Time) string {
+	var pairs []string
+	for key, expireAt := range expires {
+		if _, ok := data[key]; !ok {
+			continue
+		}
+		seconds := int64(expireAt.Sub(now).Seconds() + 0.5)
+		if seconds < 1 {
+			seconds = 1
+		}
+		pairs = append(pairs, url.QueryEscape(decodeKey(key))+"="+strconv.FormatInt(seconds, 10))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// addExpiresUpdate extends Mongo update of the user data with removal of time-to-live of the
+// top level keys which are replaced or removed, unless the update sets it again. Removal of
+// the whole user data removes all of them. Updates of the nested paths keep time-to-live
+// of the key.
+func addExpiresUpdate(update bson.M) {
+	set, _ := update["$set"].(bson.M)
+	unset := bson.M{}
+	for operator, fields := range update {
+		fieldsMap, ok := fields.(bson.M)
+		if !ok || (operator != "$set" && operator != "$unset") {
+			continue
+		}
+		for field := range fieldsMap {
+			path, ok := splitDataPath(field)
+			if !ok {
+				continue
+			}
+			if len(path) == 0 && operator == "$unset" {
+				addUpdateFields(update, "$unset", bson.M{"expires": "", "next_expiry": ""})
+				return
+			}
+			if len(path) == 1 {
+				if _, ok := set["expires."+path[0]]; !ok {
+					unset["expires."+path[0]] = ""
+				}
+			}
+		}
+	}
+	addUpdateFields(update, "$unset", unset)
+}
+
+// dropExpiredKeys removes the expired top level keys which nested paths of the update belong
+// to, before the update is applied. Otherwise the update would change the expired value,
+// which would keep it's time-to-live along with the other nested values. The removal is
+// written the same as by SweepExpired.
+//
+// It returns the condition of the update, which is moved to the version after the removal
+// if it matched the version before, since the removal isn't a change made meanwhile.
+func (app *BasicApp) dropExpiredKeys(objectUID bson.ObjectId, namespace string, update bson.M, condition *stateCondition) (*stateCondition, error) {
+	keys := make(map[string]bool)
+	for _, fields := range update {
+		fieldsMap, ok := fields.(bson.M)
+		if !ok {
+			continue
+		}
+		for field := range fieldsMap {
+			if path, ok := splitDataPath(field); ok && len(path) > 1 {
+				keys[path[0]] = true
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return condition, nil
+	}
+
+	now := time.Now()
+	selector := stateSelector(objectUID, namespace)
+	selector["next_expiry"] = bson.M{"$lte": now}
+	var state State
+	err := app.Coll.States.Find(selector).Select(bson.M{"version": 1, "expires": 1}).One(&state)
+	if err == mgo.ErrNotFound {
+		return condition, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	unset := bson.M{}
+	for key := range keys {
+		if expireAt, ok := state.Expires[key]; ok && !expireAt.After(now) {
+			unset["data."+key] = ""
+		}
+	}
+	if len(unset) == 0 {
+		return condition, nil
+	}
+	removalCondition := &stateCondition{versions: []int64{state.Version}}
+	removed, err := app.updateState(objectUID, namespace, bson.M{"$unset": unset}, removalCondition, "ttl-expiry")
+	if err == errPreconditionFailed { // changed meanwhile, the update is checked again
+		return condition, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if condition != nil && condition.matches(state.Version) {
+		condition = &stateCondition{versions: []int64{removed.Version}}
+	}
+	return condition, nil
+}
+
+// dropExpired removes the expired keys from the state data.
+func (state *State) dropExpired(now time.Time) {
+	for key, expireAt := range state.Expires {
+		if !expireAt.After(now) {
+			delete(state.Data, key)
+		}
+	}
+}
+
+// SweepExpired removes the expired keys of all the user states. Removals are written the
+// same as any other, so they are recorded in history and reported to event streams and
+// sync as deletions. States which change meanwhile are left for the next sweep, states
+// which fail to update are logged and skipped.
+//
+// It returns number of states from which the keys were removed.
+func (app *BasicApp) SweepExpired() (int, error) {
+	now := time.Now()
+	iter := app.Coll.States.Find(bson.M{"next_expiry": bson.M{"$lte": now}}).
+		Select(bson.M{"uid": 1, "namespace": 1, "version": 1, "expires": 1}).
+		Iter()
+
+	swept := 0
+	var state State
+	for iter.Next(&state) {
+		var nextExpiry time.Time
+		unset := bson.M{}
+		for key, expireAt := range state.Expires {
+			if !expireAt.After(now) {
+				unset["data."+key] = ""
+			} else if nextExpiry.IsZero() || expireAt.Before(nextExpiry) {
+				nextExpiry = expireAt
+			}
+		}
+		update := bson.M{"$unset": bson.M{"next_expiry": ""}}
+		if !nextExpiry.IsZero() {
+			update = bson.M{"$set": bson.M{"next_expiry": nextExpiry}}
+		}
+
+		var err error
+		if len(unset) == 0 { // time-to-live was removed meanwhile, no data changes
+			err = app.Coll.States.Update(bson.M{"_id": state.ID, "version": state.Version}, update)
+			if err == mgo.ErrNotFound {
+				err = nil
+			}
+		} else {
+			addUpdateFields(update, "$unset", unset)
+			condition := &stateCondition{versions: []int64{state.Version}}
+			_, err = app.updateState(stateOwner(&state), state.Namespace, update, condition, "ttl-expiry")
+			if err == nil {
+				swept++
+			}
+			if err == errPreconditionFailed {
+				err = nil
+			}
+		}
+		if err != nil { // e.g. the removal isn't valid against the schema, other states are swept
+			app.Iris.Logger().Error(err)
+		}
+		state = State{}
+	}
+	return swept, iter.Close()
+}
+
+// sweepExpiredLoop runs SweepExpired every `TTLSweepInterval`.
+func (app *BasicApp) sweepExpiredLoop() {
+	interval := app.Settings.TTLSweepInterval
+	if interval <= 0 {
+		interval = defaultTTLSweepInterval
+	}
+	for range time.Tick(interval) {
+		_, err := app.SweepExpired()
+		if err != nil {
+			app.Iris.Logger().Error(err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package basicserver
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+var errUnsupportedUpdate = errors.New("Unsupported Update")
+
+// applyUpdate returns copy of the stored user data with Mongo update applied, so the state
+// after the update can be inspected before it is written. Only the user data paths
+// are updated.
+func applyUpdate(data bson.M, update bson.M) (bson.M, error) {
+	var node interface{} = bson.M{}
+	if data != nil {
+		node = mapKeys(data, func(key string) string { return key })
+	}
+
+	for operator, fields := range update {
+		fieldsMap, ok := fields.(bson.M)
+		if !ok {
+			return nil, errUnsupportedUpdate
+		}
+		for field, value := range fieldsMap {
+			path, ok := splitDataPath(field)
+			if !ok {
+				continue
+			}
+			switch operator {
+			case "$set":
+				node = setPathValue(node, path, value)
+			case "$unset":
+				node = unsetPathValue(node, path)
+			case "$push":
+				current, exists := getPathValue(node, path)
+				array, ok := current.([]interface{})
+				if exists && !ok {
+					return nil, errUnsupportedUpdate
+				}
+				items := []interface{}{value}
+				position := len(array)
+				if modifiers, ok := value.(bson.M); ok {
+					if each, ok := modifiers["$each"].([]interface{}); ok {
+						items = each
+					}
+					if p, ok := modifiers["$position"].(int); ok && p >= 0 && p < position {
+						position = p
+					}
+				}
+				merged := make([]interface{}, 0, len(array)+len(items))
+				merged = append(merged, array[:position]...)
+				merged = append(merged, items...)
+				merged = append(merged, array[position:]...)
+				node = setPathValue(node, path, merged)
+			case "$inc":
+				current, _ := getPathValue(node, path)
+				sum, ok := addNumbers(current, value)
+				if !ok {
+					return nil, errUnsupportedUpdate
+				}
+				node = setPathValue(node, path, sum)
+			case "$min", "$max":
+				current, exists := getPathValue(node, path)
+				order, comparable := compareValues(value, current)
+				if !exists || (comparable && (order < 0) == (operator == "$min") && order != 0) {
+					node = setPathValue(node, path, value)
+				}
+			case "$pull", "$addToSet":
+				current, exists := getPathValue(node, path)
+				array, ok := current.([]interface{})
+				if exists && !ok {
+					return nil, errUnsupportedUpdate
+				}
+				if !exists && operator == "$pull" {
+					continue
+				}
+				result := make([]interface{}, 0, len(array)+1)
+				found := false
+				for _, item := range array {
+					if equalValues(item, value) {
+						found = true
+						if operator == "$pull" {
+							continue
+						}
+					}
+					result = append(result, item)
+				}
+				if operator == "$addToSet" && !found {
+					result = append(result, value)
+				}
+				node = setPathValue(node, path, result)
+			default:
+				return nil, errUnsupportedUpdate
+			}
+		}
+	}
+
+	switch result := node.(type) {
+	case bson.M:
+		return result, nil
+	case map[string]interface{}:
+		return bson.M(result), nil
+	}
+	return bson.M{}, nil
+}
+
+// addNumbers returns sum of the numbers the same way as Mongo `$inc` does. Missing value
+// stands for 0.
+func addNumbers(current interface{}, value interface{}) (interface{}, bool) {
+	increment, ok := toNumber(value)
+	if !ok {
+		return nil, false
+	}
+	if current == nil {
+		return value, true
+	}
+	number, ok := toNumber(current)
+	if !ok {
+		return nil, false
+	}
+	_, currentInt := current.(int)
+	_, valueInt := value.(int)
+	if currentInt && valueInt {
+		return current.(int) + value.(int), true
+	}
+	return number + increment, true
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// compareValues compares numbers or strings. It returns false for the other values.
+func compareValues(a interface{}, b interface{}) (int, bool) {
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		switch {
+		case !ok:
+			return 0, false
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	if x, ok := a.(string); ok {
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	}
+	return 0, false
+}
+
+// equalValues reports whether the values are equal once stored, e.g. numbers of
+// different types.
+func equalValues(a interface{}, b interface{}) bool {
+	x, errA := json.Marshal(a)
+	y, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(x) == string(y)
+}
+
+// isInvalidUpdateError reports whether Mongo refused the update since it doesn't fit the
+// stored data, e.g. increase of a string value.
+func isInvalidUpdateError(err error) bool {
+	queryError, ok := err.(*mgo.QueryError)
+	if !ok {
+		return false
+	}
+	switch queryError.Code {
+	case 2, 14, 28, 40: // BadValue, TypeMismatch, PathNotViable, ConflictingUpdateOperators
+		return true
+	}
+	return false
+}
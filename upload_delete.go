@@ -0,0 +1,50 @@
+package basicserver
+
+import (
+	"github.com/kataras/iris"
+)
+
+// ServeUploadDelete serves
+// Method:   DELETE
+// Resource: http://localhost/api/uploads/{id:string}
+//
+// This resource requires `Authorization` and `Tus-Resumable` headers, e.g.:
+//
+//    Authorization: Bearer {token}
+//    Tus-Resumable: 1.0.0
+//
+// Terminates the upload, following tus 1.0.0 termination extension. Received chunks are
+// removed. If the upload is already completed, the stored file is kept.
+//
+// If everything goes well, then this will return status code `204` and no response body.
+//
+// In case of unknown or removed upload, this will return status code `404` and `text/plain`
+// error message as a response. In case of error, this will return status code `412`
+// (unsupported tus version) or `500`.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeUploadDelete() iris.Handler {
+	return func(ctx iris.Context) {
+		if !app.checkTusResumable(ctx) {
+			return
+		}
+		uid := ctx.Values().Get("uid").(string)
+
+		upload, err := app.findUpload(uid, ctx.Params().Get("id"))
+		if err == nil {
+			err = app.removeUpload(upload.ID)
+		}
+		if err != nil {
+			if err == errNoSuchUpload {
+				app.HandleError(err, ctx, iris.StatusNotFound)
+				ctx.WriteString(err.Error())
+			} else {
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+			}
+			return
+		}
+		ctx.StatusCode(iris.StatusNoContent)
+	}
+}
@@ -0,0 +1,57 @@
+package basicserver
+
+import (
+	"strconv"
+
+	"github.com/kataras/iris"
+)
+
+// ServeUploadHead serves
+// Method:   HEAD
+// Resource: http://localhost/api/uploads/{id:string}
+//
+// This resource requires `Authorization` and `Tus-Resumable` headers, e.g.:
+//
+//    Authorization: Bearer {token}
+//    Tus-Resumable: 1.0.0
+//
+// If everything goes well, then this will return status code `200` and offset at which the
+// upload should be resumed, along with it's length and metadata:
+//
+//    Upload-Offset: 52428800
+//    Upload-Length: 104857600
+//    Upload-Metadata: filename dmlkZW8ubXA0,filetype dmlkZW8vbXA0
+//
+// Offset of the completed upload is equal to it's length.
+//
+// In case of unknown or removed upload, this will return status code `404`. In case of
+// error, this will return status code `412` (unsupported tus version) or `500`.
+//
+// In case of invalid/expired token, this will return status code `401`.
+//
+func (app *BasicApp) ServeUploadHead() iris.Handler {
+	return func(ctx iris.Context) {
+		ctx.Header("Cache-Control", "no-store")
+		if !app.checkTusResumable(ctx) {
+			return
+		}
+		uid := ctx.Values().Get("uid").(string)
+
+		upload, err := app.findUpload(uid, ctx.Params().Get("id"))
+		if err != nil {
+			if err == errNoSuchUpload {
+				app.HandleError(err, ctx, iris.StatusNotFound)
+			} else {
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+			}
+			return
+		}
+
+		ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		ctx.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		if upload.Metadata != "" {
+			ctx.Header("Upload-Metadata", upload.Metadata)
+		}
+		ctx.StatusCode(iris.StatusOK)
+	}
+}
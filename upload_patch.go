@@ -0,0 +1,120 @@
+package basicserver
+
+import (
+	"hash"
+	"strconv"
+
+	"github.com/kataras/iris"
+)
+
+// ServeUploadPatch serves
+// Method:   PATCH
+// Resource: http://localhost/api/uploads/{id:string}
+//
+// This resource requires `Authorization` header along with tus protocol headers, e.g.:
+//
+//    Authorization: Bearer {token}
+//    Tus-Resumable: 1.0.0
+//    Content-Type: application/offset+octet-stream
+//    Upload-Offset: 52428800
+//    Upload-Checksum: sha1 Kq5sNclPz7QV2+lfQIuc6R7oRu0=
+//
+// Stores the chunk sent as request body at given offset, which has to be equal to the
+// offset returned by HEAD /api/uploads/{id}. Optional `Upload-Checksum` header verifies
+// the chunk with "md5", "sha1" or "sha256" digest, following tus 1.0.0 checksum extension.
+// Interrupted chunks are discarded, so the upload is resumed at the offset of the last
+// complete chunk.
+//
+// Chunks are limited to `MaxBodySize` of the upload policy. Once the last chunk is received,
+// the file is stored the same as uploaded with POST /api/file, overwriting the file with
+// the same name.
+//
+// If everything goes well, then this will return status code `204` and the new offset:
+//
+//    Upload-Offset: 62914560
+//
+// In case of chunk exceeding the upload length, unsupported checksum algorithm or other
+// error, this will return status code `400`, `404` (unknown upload), `409` (offset
+// mismatch), `412` (unsupported tus version), `413`, `415` (Content-Type other than
+// "application/offset+octet-stream" or file type not allowed), `460` (checksum mismatch),
+// `500` or `507` and `text/plain` error message as response. Completed file which exceeds
+// the quota or breaks the upload policy is removed along with the upload.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeUploadPatch() iris.Handler {
+	return func(ctx iris.Context) {
+		if !app.checkTusResumable(ctx) {
+			return
+		}
+		if ctx.GetHeader("Content-Type") != tusContentType {
+			app.HandleError(errUnsupportedUploadType, ctx, iris.StatusUnsupportedMediaType)
+			ctx.WriteString(errUnsupportedUploadType.Error())
+			return
+		}
+		offset, err := parseUploadSize(ctx.GetHeader("Upload-Offset"), errInvalidUploadOffset)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+		var checksum hash.Hash
+		var expected []byte
+		if header := ctx.GetHeader("Upload-Checksum"); header != "" {
+			checksum, expected, err = parseUploadChecksum(header)
+			if err != nil {
+				app.HandleError(err, ctx, iris.StatusBadRequest)
+				ctx.WriteString(err.Error())
+				return
+			}
+		}
+
+		policy := app.uploadPolicy(ctx)
+		_, err = policy.limitBody(ctx)
+		if err != nil {
+			app.handleFileError(err, ctx)
+			return
+		}
+
+		uid := ctx.Values().Get("uid").(string)
+		upload, err := app.findUpload(uid, ctx.Params().Get("id"))
+		if err == nil && offset != upload.Offset {
+			err = errUploadOffsetMismatch
+		}
+		if err == nil {
+			offset, err = app.appendUpload(upload, ctx.Request().Body, checksum, expected)
+		}
+		if err == nil && offset == upload.Length && !upload.Completed {
+			err = app.completeUpload(upload, policy)
+			if err != nil {
+				app.removeUpload(upload.ID)
+			}
+		}
+		if err != nil {
+			app.handleUploadError(err, ctx)
+			return
+		}
+
+		ctx.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+		ctx.StatusCode(iris.StatusNoContent)
+	}
+}
+
+// handleUploadError handles error of the upload chunk.
+func (app *BasicApp) handleUploadError(err error, ctx iris.Context) {
+	switch err {
+	case errNoSuchUpload:
+		app.HandleError(err, ctx, iris.StatusNotFound)
+	case errUploadOffsetMismatch:
+		app.HandleError(err, ctx, iris.StatusConflict)
+	case errUploadExceedsLength:
+		app.HandleError(err, ctx, iris.StatusBadRequest)
+	case errChecksumMismatch:
+		app.HandleError(err, ctx, statusChecksumMismatch)
+	default:
+		app.handleFileError(err, ctx)
+		return
+	}
+	ctx.WriteString(err.Error())
+}
@@ -0,0 +1,216 @@
+package basicserver
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kataras/iris"
+)
+
+var (
+	errUploadTooLarge     = errors.New("Upload Too Large")
+	errFileTypeNotAllowed = errors.New("File Type Not Allowed")
+	errFileNameNotAllowed = errors.New("File Name Not Allowed")
+	errFileExtNotAllowed  = errors.New("File Extension Not Allowed")
+)
+
+// UploadPolicy limits files accepted by POST /api/file and /api/uploads. Zero values
+// don't limit anything:
+//
+//    `MaxBodySize` size of the request body, which is enforced while it's being read
+//    `MaxFileSize` size of a single file
+//    `AllowedTypes` content types of the accepted files, e.g. "image/*" or "application/pdf"
+//    `DeniedTypes` content types of the rejected files, checked after `AllowedTypes`
+//    `MaxNameLength` number of characters of the file name
+//    `NamePattern` regular expression which file names have to match
+//    `DeniedExtensions` extensions of the rejected file names, e.g. ".exe"
+//
+// Types are checked against the type detected from the file content, as well as against
+// the type the file is stored with. So neither the declared type nor the extension allows
+// file content of the other type. Plain text content is refined by the extension only to
+// textual types, such as "text/css" or "application/json", and unknown binary content
+// is "application/octet-stream".
+//
+type UploadPolicy struct {
+	MaxBodySize      int64
+	MaxFileSize      int64
+	AllowedTypes     []string
+	DeniedTypes      []string
+	MaxNameLength    int
+	NamePattern      *regexp.Regexp
+	DeniedExtensions []string
+}
+
+// WithUploadPolicy returns middleware which overrides `UploadPolicy` setting for the route,
+// e.g. for custom upload route of the app:
+//
+//    app.Iris.Post("/avatar", app.RequireAuth(), app.WithUploadPolicy(&basicserver.UploadPolicy{
+//        MaxBodySize:  1 << 20,
+//        AllowedTypes: []string{"image/jpeg", "image/png"},
+//    }), app.ServeFilePost())
+//
+func (app *BasicApp) WithUploadPolicy(policy *UploadPolicy) iris.Handler {
+	return func(ctx iris.Context) {
+		ctx.Values().Set("upload_policy", policy)
+		ctx.Next()
+	}
+}
+
+// uploadPolicy returns policy of the route, or `UploadPolicy` setting.
+func (app *BasicApp) uploadPolicy(ctx iris.Context) *UploadPolicy {
+	if policy, ok := ctx.Values().Get("upload_policy").(*UploadPolicy); ok && policy != nil {
+		return policy
+	}
+	return &app.Settings.UploadPolicy
+}
+
+// limitBody limits the request body to `MaxBodySize`. Declared `Content-Length` is checked
+// right away, the actual size while the body is read.
+func (policy *UploadPolicy) limitBody(ctx iris.Context) (*limitedBody, error) {
+	request := ctx.Request()
+	body := &limitedBody{ReadCloser: request.Body, remaining: policy.MaxBodySize}
+	if policy.MaxBodySize <= 0 {
+		body.remaining = -1
+		return body, nil
+	}
+	if request.ContentLength > policy.MaxBodySize {
+		return nil, errUploadTooLarge
+	}
+	request.Body = body
+	return body, nil
+}
+
+// limitedBody fails with errUploadTooLarge once more than the allowed number of bytes is
+// read. Negative number stands for no limit.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (body *limitedBody) Read(p []byte) (int, error) {
+	if body.remaining < 0 {
+		return body.ReadCloser.Read(p)
+	}
+	if body.remaining == 0 {
+		// the body might end exactly at the limit
+		var probe [1]byte
+		n, err := body.ReadCloser.Read(probe[:])
+		if n == 0 {
+			return 0, err
+		}
+		body.exceeded = true
+		return 0, errUploadTooLarge
+	}
+	if int64(len(p)) > body.remaining {
+		p = p[:body.remaining]
+	}
+	n, err := body.ReadCloser.Read(p)
+	body.remaining -= int64(n)
+	return n, err
+}
+
+// checkName checks the file name against the name rules.
+func (policy *UploadPolicy) checkName(name string) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.MaxNameLength > 0 && utf8.RuneCountInString(name) > policy.MaxNameLength {
+		return errFileNameNotAllowed
+	}
+	if policy.NamePattern != nil && !policy.NamePattern.MatchString(name) {
+		return errFileNameNotAllowed
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, denied := range policy.DeniedExtensions {
+		if ext != "" && ext == strings.ToLower(denied) {
+			return errFileExtNotAllowed
+		}
+	}
+	return nil
+}
+
+// checkSize checks size of a single file.
+func (policy *UploadPolicy) checkSize(size int64) error {
+	if policy != nil && policy.MaxFileSize > 0 && size > policy.MaxFileSize {
+		return errFileTooLarge
+	}
+	return nil
+}
+
+// checkTypes checks all the given content types of the file against the type lists.
+func (policy *UploadPolicy) checkTypes(contentTypes ...string) error {
+	if policy == nil || (len(policy.AllowedTypes) == 0 && len(policy.DeniedTypes) == 0) {
+		return nil
+	}
+	for _, contentType := range contentTypes {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return errFileTypeNotAllowed
+		}
+		if len(policy.AllowedTypes) > 0 && !matchesMediaType(mediaType, policy.AllowedTypes) {
+			return errFileTypeNotAllowed
+		}
+		if matchesMediaType(mediaType, policy.DeniedTypes) {
+			return errFileTypeNotAllowed
+		}
+	}
+	return nil
+}
+
+// policyContentType returns type of the file content checked by the upload policy.
+func policyContentType(name string, detected string) string {
+	if !strings.HasPrefix(detected, "text/plain") {
+		return detected
+	}
+	byExtension, _, err := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(name)))
+	if err == nil && isTextType(byExtension) {
+		return byExtension
+	}
+	return detected
+}
+
+func isTextType(mediaType string) bool {
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+xml") || strings.HasSuffix(mediaType, "+json")
+}
+
+// matchesMediaType reports whether the media type matches any of the patterns, such as
+// "image/png", "image/*" or "*/*".
+func matchesMediaType(mediaType string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "*/*" || pattern == mediaType {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleFileError handles error of the stored file, including the upload policy errors.
+func (app *BasicApp) handleFileError(err error, ctx iris.Context) {
+	switch err {
+	case errUploadTooLarge:
+		app.HandleError(err, ctx, iris.StatusRequestEntityTooLarge)
+	case errFileTypeNotAllowed, errFileExtNotAllowed:
+		app.HandleError(err, ctx, iris.StatusUnsupportedMediaType)
+	case errFileNameNotAllowed:
+		app.HandleError(err, ctx, iris.StatusBadRequest)
+	default:
+		app.handleQuotaError(err, ctx)
+		return
+	}
+	ctx.WriteString(err.Error())
+}
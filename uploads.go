@@ -0,0 +1,422 @@
+package basicserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+const (
+	tusVersion              = "1.0.0"
+	tusExtensions           = "creation,termination,checksum"
+	tusChecksumAlgorithms   = "md5,sha1,sha256"
+	tusContentType          = "application/offset+octet-stream"
+	defaultUploadExpiration = 24 * time.Hour
+	uploadSweepInterval     = 10 * time.Minute
+	// statusChecksumMismatch is tus status code of the chunk not matching it's checksum.
+	statusChecksumMismatch = 460
+)
+
+var (
+	errUnsupportedTusVersion = errors.New("Unsupported Tus Version")
+	errInvalidUploadLength   = errors.New("Invalid Upload-Length")
+	errInvalidUploadOffset   = errors.New("Invalid Upload-Offset")
+	errInvalidUploadMetadata = errors.New("Invalid Upload-Metadata")
+	errUploadNameMissing     = errors.New("Upload Name Not Provided")
+	errNoSuchUpload          = errors.New("No Such Upload")
+	errUploadOffsetMismatch  = errors.New("Upload Offset Mismatch")
+	errUploadExceedsLength   = errors.New("Upload Exceeds Length")
+	errUnsupportedChecksum   = errors.New("Unsupported Checksum Algorithm")
+	errChecksumMismatch      = errors.New("Checksum Mismatch")
+	errUnsupportedUploadType = errors.New("Unsupported Content-Type")
+)
+
+// Upload is an user's resumable upload entity. Received chunks are stored as separate
+// GridFS files until the whole file is received:
+//
+//    `ID` upload id
+//    `UID` uid of the uploading user
+//    `Name` name under which the file is stored
+//    `Length` total size of the file
+//    `Offset` number of bytes received so far
+//    `Metadata` `Upload-Metadata` header of the upload
+//    `Parts` ids of the stored chunks in order
+//    `Completed` whether the file is stored
+//    `ReservedBytes` and `ReservedCount` files usage reserved for the file until the upload
+//      is completed or removed, so the quota is checked against the declared length up front
+//    `CreatedAt` time at which upload was created
+//    `ExpiresAt` time at which incomplete upload is removed, extended with every chunk
+//
+type Upload struct {
+	ID        bson.ObjectId   `bson:"_id"`
+	UID       bson.ObjectId   `bson:"uid"`
+	Name      string          `bson:"name"`
+	Length    int64           `bson:"length"`
+	Offset    int64           `bson:"offset"`
+	Metadata  string          `bson:"metadata,omitempty"`
+	Parts     []bson.ObjectId `bson:"parts,omitempty"`
+	Completed bool            `bson:"completed"`
+	CreatedAt time.Time       `bson:"created_at"`
+	ExpiresAt time.Time       `bson:"expires_at"`
+
+	ReservedBytes int64 `bson:"reserved_bytes"`
+	ReservedCount int   `bson:"reserved_count"`
+}
+
+// tusMetadata holds the values of `Upload-Metadata` header:
+//
+//    `filename` or `name` name of the file, required
+//    `filetype` or `type` declared content type of the file
+//    `metadata` custom JSON metadata of the file
+//
+type tusMetadata struct {
+	name        string
+	contentType string
+	data        map[string]interface{}
+}
+
+// parseUploadMetadata parses comma separated `key base64value` pairs of `Upload-Metadata`
+// header.
+func parseUploadMetadata(header string) (*tusMetadata, error) {
+	if strings.TrimSpace(header) == "" {
+		return nil, errUploadNameMissing
+	}
+	values := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, errInvalidUploadMetadata
+		}
+		value := ""
+		if len(fields) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, errInvalidUploadMetadata
+			}
+			value = string(decoded)
+		}
+		values[fields[0]] = value
+	}
+
+	metadata := &tusMetadata{name: values["filename"], contentType: values["filetype"]}
+	if metadata.name == "" {
+		metadata.name = values["name"]
+	}
+	if metadata.contentType == "" {
+		metadata.contentType = values["type"]
+	}
+	if metadata.name == "" {
+		return nil, errUploadNameMissing
+	}
+	err := validateKey(metadata.name)
+	if err != nil {
+		return nil, err
+	}
+	if data, ok := values["metadata"]; ok {
+		metadata.data, err = parseFileMetadata(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return metadata, nil
+}
+
+// parseUploadChecksum parses `Upload-Checksum` header, e.g. "sha1 {base64 digest}".
+func parseUploadChecksum(header string) (hash.Hash, []byte, error) {
+	fields := strings.Fields(header)
+	if len(fields) != 2 {
+		return nil, nil, errUnsupportedChecksum
+	}
+	var checksum hash.Hash
+	switch fields[0] {
+	case "md5":
+		checksum = md5.New()
+	case "sha1":
+		checksum = sha1.New()
+	case "sha256":
+		checksum = sha256.New()
+	default:
+		return nil, nil, errUnsupportedChecksum
+	}
+	expected, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, nil, errUnsupportedChecksum
+	}
+	return checksum, expected, nil
+}
+
+// parseUploadSize parses non-negative `Upload-Length` or `Upload-Offset` header.
+func parseUploadSize(header string, invalid error) (int64, error) {
+	size, err := strconv.ParseInt(header, 10, 64)
+	if err != nil || size < 0 {
+		return 0, invalid
+	}
+	return size, nil
+}
+
+// checkTusResumable sets `Tus-Resumable` header of the response and checks the protocol
+// version requested by the client.
+func (app *BasicApp) checkTusResumable(ctx iris.Context) bool {
+	ctx.Header("Tus-Resumable", tusVersion)
+	if ctx.GetHeader("Tus-Resumable") != tusVersion {
+		ctx.Header("Tus-Version", tusVersion)
+		app.HandleError(errUnsupportedTusVersion, ctx, iris.StatusPreconditionFailed)
+		ctx.WriteString(errUnsupportedTusVersion.Error())
+		return false
+	}
+	return true
+}
+
+// uploadExpiration returns time after which incomplete uploads are removed.
+func (app *BasicApp) uploadExpiration() time.Duration {
+	if app.Settings.UploadExpiration > 0 {
+		return app.Settings.UploadExpiration
+	}
+	return defaultUploadExpiration
+}
+
+// findUpload returns upload of the user.
+func (app *BasicApp) findUpload(uid string, id string) (*Upload, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, errNoSuchUpload
+	}
+	var upload Upload
+	err := app.Coll.Uploads.Find(bson.M{"_id": bson.ObjectIdHex(id), "uid": bson.ObjectIdHex(uid)}).One(&upload)
+	if err == mgo.ErrNotFound {
+		return nil, errNoSuchUpload
+	}
+	return &upload, err
+}
+
+// uploadPartName returns stored file name of the chunk starting at given offset. Chunks are
+// kept apart from the user files, which are named `uid:name`.
+func uploadPartName(uploadID bson.ObjectId, offset int64) string {
+	return fmt.Sprintf("upload:%s:%020d", uploadID.Hex(), offset)
+}
+
+// appendUpload stores the chunk read from the reader at the current offset of the upload.
+// The chunk is verified against the checksum, if any, and it's never stored past the
+// upload length. New offset of the upload is returned.
+func (app *BasicApp) appendUpload(upload *Upload, reader io.Reader, checksum hash.Hash, expected []byte) (int64, error) {
+	buffered := bufio.NewReader(reader)
+	if _, err := buffered.Peek(1); err == io.EOF {
+		if checksum != nil && !bytes.Equal(checksum.Sum(nil), expected) {
+			return upload.Offset, errChecksumMismatch
+		}
+		return upload.Offset, nil
+	}
+
+	part := &storedFile{ID: bson.NewObjectId(), Filename: uploadPartName(upload.ID, upload.Offset)}
+	err := app.createFile(part, &chunkReader{
+		reader:    buffered,
+		remaining: upload.Length - upload.Offset,
+		checksum:  checksum,
+		expected:  expected,
+	}, -1)
+	if mgo.IsDup(err) { // chunk at the same offset is being stored
+		return 0, errUploadOffsetMismatch
+	}
+	if err != nil {
+		return upload.Offset, err
+	}
+	partID := part.ID
+
+	// the chunk is accepted only if no other chunk was stored meanwhile
+	offset := upload.Offset + part.Length
+	err = app.Coll.Uploads.Update(bson.M{"_id": upload.ID, "offset": upload.Offset, "completed": false}, bson.M{
+		"$set":  bson.M{"offset": offset, "expires_at": time.Now().Add(app.uploadExpiration())},
+		"$push": bson.M{"parts": partID},
+	})
+	if err != nil {
+		app.removeStoredFileId(partID)
+		if err == mgo.ErrNotFound {
+			return 0, errUploadOffsetMismatch
+		}
+		return 0, err
+	}
+	upload.Offset = offset
+	upload.Parts = append(upload.Parts, partID)
+	return offset, nil
+}
+
+// completeUpload stores the received chunks as the user file, the same as uploaded with
+// POST /api/file, and removes the chunks. The file is checked against the upload policy and
+// the user quota once again, since the file with the same name might be stored or removed
+// meanwhile. Usage reserved by the upload is taken over by the stored file.
+func (app *BasicApp) completeUpload(upload *Upload, policy *UploadPolicy) error {
+	metadata, err := parseUploadMetadata(upload.Metadata)
+	if err != nil {
+		return err
+	}
+
+	var reserved Upload
+	_, err = app.Coll.Uploads.Find(bson.M{"_id": upload.ID, "completed": false}).Apply(mgo.Change{
+		Update: bson.M{"$set": bson.M{"reserved_bytes": 0, "reserved_count": 0}},
+	}, &reserved)
+	if err == mgo.ErrNotFound {
+		return errNoSuchUpload
+	}
+	if err != nil {
+		return err
+	}
+
+	reader := &uploadReader{app: app, parts: upload.Parts}
+	defer reader.Close()
+	_, err = app.storeFile(upload.UID, fileUpload{
+		name:        metadata.name,
+		size:        upload.Length,
+		reader:      reader,
+		contentType: metadata.contentType,
+		metadata:    metadata.data,
+		policy:      policy,
+
+		reservedBytes: reserved.ReservedBytes,
+		reservedCount: reserved.ReservedCount,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = app.removeUploadParts(upload.ID)
+	if err != nil {
+		app.Iris.Logger().Error(err)
+	}
+	upload.Completed = true
+	return app.Coll.Uploads.UpdateId(upload.ID, bson.M{
+		"$set":   bson.M{"completed": true},
+		"$unset": bson.M{"parts": ""},
+	})
+}
+
+// removeUploadParts removes all the stored chunks of the upload.
+func (app *BasicApp) removeUploadParts(uploadID bson.ObjectId) error {
+	prefix := "upload:" + uploadID.Hex()
+	return app.removeStoredFiles(bson.M{"filename": bson.M{"$gt": prefix + ":", "$lt": prefix + ";"}})
+}
+
+// removeUpload removes the upload along with it's chunks, and releases it's reserved usage.
+// Files of the completed uploads are kept.
+func (app *BasicApp) removeUpload(uploadID bson.ObjectId) error {
+	err := app.removeUploadParts(uploadID)
+	if err != nil {
+		return err
+	}
+	var removed Upload
+	_, err = app.Coll.Uploads.FindId(uploadID).Apply(mgo.Change{Remove: true}, &removed)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	if err != nil || (removed.ReservedBytes == 0 && removed.ReservedCount == 0) {
+		return err
+	}
+	return app.trackFileUsage(removed.UID, -removed.ReservedBytes, -removed.ReservedCount)
+}
+
+// SweepUploads removes the uploads which weren't continued for `UploadExpiration`, along
+// with their chunks. Number of removed uploads is returned. It runs in background once
+// the server is started.
+func (app *BasicApp) SweepUploads() (int, error) {
+	removed := 0
+	var upload Upload
+	iter := app.Coll.Uploads.Find(bson.M{"expires_at": bson.M{"$lte": time.Now()}}).Select(bson.M{"_id": 1}).Iter()
+	for iter.Next(&upload) {
+		err := app.removeUpload(upload.ID)
+		if err != nil {
+			iter.Close()
+			return removed, err
+		}
+		removed++
+	}
+	return removed, iter.Close()
+}
+
+// sweepUploadsLoop runs SweepUploads periodically.
+func (app *BasicApp) sweepUploadsLoop() {
+	for range time.Tick(uploadSweepInterval) {
+		_, err := app.SweepUploads()
+		if err != nil {
+			app.Iris.Logger().Error(err)
+		}
+	}
+}
+
+// chunkReader reads the chunk of the upload, which fails once the chunk exceeds the
+// remaining length of the upload, or at the end if it doesn't match the checksum.
+type chunkReader struct {
+	reader    io.Reader
+	remaining int64
+	checksum  hash.Hash
+	expected  []byte
+}
+
+func (reader *chunkReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	reader.remaining -= int64(n)
+	if reader.remaining < 0 {
+		return 0, errUploadExceedsLength
+	}
+	if reader.checksum != nil {
+		reader.checksum.Write(p[:n])
+		if err == io.EOF && !bytes.Equal(reader.checksum.Sum(nil), reader.expected) {
+			return n, errChecksumMismatch
+		}
+	}
+	return n, err
+}
+
+// uploadReader reads the stored chunks of the upload one after another.
+type uploadReader struct {
+	app     *BasicApp
+	parts   []bson.ObjectId
+	current *storedFile
+}
+
+func (reader *uploadReader) Read(p []byte) (int, error) {
+	for {
+		if reader.current == nil {
+			if len(reader.parts) == 0 {
+				return 0, io.EOF
+			}
+			file, err := reader.app.openFileId(reader.parts[0])
+			if err != nil {
+				return 0, err
+			}
+			reader.current = file
+			reader.parts = reader.parts[1:]
+		}
+		n, err := reader.current.Read(p)
+		if err == io.EOF {
+			reader.current.Close()
+			reader.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Close closes the chunk being read.
+func (reader *uploadReader) Close() error {
+	if reader.current == nil {
+		return nil
+	}
+	err := reader.current.Close()
+	reader.current = nil
+	return err
+}
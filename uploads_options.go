@@ -0,0 +1,39 @@
+package basicserver
+
+import (
+	"strconv"
+
+	"github.com/kataras/iris"
+)
+
+// ServeUploadsOptions serves
+// Method:   OPTIONS
+// Resource: http://localhost/api/uploads
+//
+// Returns tus protocol capabilities of the server with status code `204`, e.g.:
+//
+//    Tus-Resumable: 1.0.0
+//    Tus-Version: 1.0.0
+//    Tus-Extension: creation,termination,checksum
+//    Tus-Checksum-Algorithm: md5,sha1,sha256
+//    Tus-Max-Size: 104857600
+//
+// `Tus-Max-Size` is the single file size of `Quota` or `UploadPolicy` setting, if it's
+// limited. This resource doesn't require `Authorization` header.
+//
+func (app *BasicApp) ServeUploadsOptions() iris.Handler {
+	return func(ctx iris.Context) {
+		ctx.Header("Tus-Resumable", tusVersion)
+		ctx.Header("Tus-Version", tusVersion)
+		ctx.Header("Tus-Extension", tusExtensions)
+		ctx.Header("Tus-Checksum-Algorithm", tusChecksumAlgorithms)
+		maxSize := app.Settings.Quota.FileSize
+		if policySize := app.Settings.UploadPolicy.MaxFileSize; policySize > 0 && (maxSize <= 0 || policySize < maxSize) {
+			maxSize = policySize
+		}
+		if maxSize > 0 {
+			ctx.Header("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+		}
+		ctx.StatusCode(iris.StatusNoContent)
+	}
+}
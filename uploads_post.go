@@ -0,0 +1,139 @@
+package basicserver
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeUploadsPost serves
+// Method:   POST
+// Resource: http://localhost/api/uploads
+//
+// This resource requires `Authorization` header along with tus protocol headers, e.g.:
+//
+//    Authorization: Bearer {token}
+//    Tus-Resumable: 1.0.0
+//    Upload-Length: 104857600
+//    Upload-Metadata: filename dmlkZW8ubXA0,filetype dmlkZW8vbXA0
+//
+// Creates resumable upload of the file, following tus 1.0.0 creation extension. Values of
+// `Upload-Metadata` header are base64 encoded:
+//
+//    `filename` (or `name`) name of the file, which will be it's id, required
+//    `filetype` (or `type`) declared content type of the file
+//    `metadata` custom JSON metadata of the file, the same as for POST /api/file
+//
+// File content is sent with PATCH /api/uploads/{id} requests. Incomplete uploads are
+// removed after `UploadExpiration` (24 hours by default) since the last received chunk.
+//
+// Upload is checked against `UploadPolicy` setting, or the policy of the route set with
+// `WithUploadPolicy`, the same as POST /api/file. Name, length and declared type are checked
+// when the upload is created, content type when it's completed. Rejected upload returns
+// status code `400`, `413` or `415`.
+//
+// Upload counts towards user quota with it's declared length from the time it's created,
+// until it's completed or removed. If the file is larger than allowed single file size,
+// this will return status code `413`. If the file would exceed total size or number of
+// user files, along with the other pending uploads, this will return status code `507`.
+// Quota is checked once again when the file is completed.
+//
+// If everything goes well, then this will return status code `201` and no response body.
+// Url of the upload is returned in `Location` header, e.g.:
+//
+//    Location: http://localhost/api/uploads/5c8f6a5e2f8fb814b56fa186
+//
+// In case of error, this will return status code `400`, `412` (unsupported tus version),
+// `413`, `415`, `500` or `507` and `text/plain` error message as response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeUploadsPost() iris.Handler {
+	return func(ctx iris.Context) {
+		if !app.checkTusResumable(ctx) {
+			return
+		}
+		length, err := parseUploadSize(ctx.GetHeader("Upload-Length"), errInvalidUploadLength)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+		metadataHeader := ctx.GetHeader("Upload-Metadata")
+		metadata, err := parseUploadMetadata(metadataHeader)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusBadRequest)
+			ctx.WriteString(err.Error())
+			return
+		}
+
+		// the policy is checked once again with the actual content
+		policy := app.uploadPolicy(ctx)
+		err = policy.checkName(metadata.name)
+		if err == nil {
+			err = policy.checkSize(length)
+		}
+		if err == nil && metadata.contentType != "" {
+			err = policy.checkTypes(metadata.contentType)
+		}
+		if err != nil {
+			app.handleFileError(err, ctx)
+			return
+		}
+
+		uid := ctx.Values().Get("uid").(string)
+		objectUID := bson.ObjectIdHex(uid)
+
+		var overwritten int64
+		oldFile, err := app.openFile(uid + ":" + metadata.name)
+		overwrite := err == nil
+		if overwrite {
+			overwritten = oldFile.Length
+		}
+		// declared length is reserved until the upload is completed or removed
+		reservedBytes, reservedCount := length-overwritten, 1
+		if overwrite {
+			reservedCount = 0
+		}
+		err = app.reserveFileQuota(objectUID, length, reservedBytes, reservedCount)
+		if err != nil {
+			app.handleQuotaError(err, ctx)
+			return
+		}
+
+		now := time.Now()
+		upload := &Upload{
+			ID:        bson.NewObjectId(),
+			UID:       objectUID,
+			Name:      metadata.name,
+			Length:    length,
+			Metadata:  metadataHeader,
+			CreatedAt: now,
+			ExpiresAt: now.Add(app.uploadExpiration()),
+
+			ReservedBytes: reservedBytes,
+			ReservedCount: reservedCount,
+		}
+		err = app.Coll.Uploads.Insert(upload)
+		if err != nil {
+			app.trackFileUsage(objectUID, -reservedBytes, -reservedCount)
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		// empty file is complete right away
+		if length == 0 {
+			err = app.completeUpload(upload, policy)
+			if err != nil {
+				app.removeUpload(upload.ID)
+				app.handleFileError(err, ctx)
+				return
+			}
+		}
+
+		ctx.Header("Location", app.absoluteURL(ctx, "/api/uploads/"+upload.ID.Hex()))
+		ctx.StatusCode(iris.StatusCreated)
+	}
+}
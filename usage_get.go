@@ -0,0 +1,91 @@
+package basicserver
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/kataras/iris"
+)
+
+// ServeUsageGet serves
+// Method:   GET
+// Resource: http://localhost/api/usage
+//
+// This resource requires `Authorization` header, e.g.:
+//
+//    Authorization: Bearer {token}
+//
+// If everything goes well, then this will return status code `200` and `application/json`
+// response with user storage usage and it's limits. Sizes are in bytes and limit `0`
+// stands for no limit:
+//
+//    {
+//      "usage": {
+//        "file_bytes": 1048576,
+//        "file_count": 2,
+//        "state_bytes": 2048
+//      },
+//      "limits": {
+//        "file_bytes": 104857600,
+//        "file_count": 100,
+//        "file_size": 10485760,
+//        "state_bytes": 1048576
+//      }
+//    }
+//
+// In case of error, this will return status code `500` and `text/plain` error
+// message as a response.
+//
+// In case of invalid/expired token, this will return status code `401` and `text/plain`
+// error message as a response.
+//
+func (app *BasicApp) ServeUsageGet() iris.Handler {
+	return func(ctx iris.Context) {
+		uid := ctx.Values().Get("uid").(string)
+		objectUID := bson.ObjectIdHex(uid)
+
+		quota, err := app.userQuota(objectUID)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+		usage, err := app.userUsage(objectUID)
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		// states of all the namespaces are counted
+		stateBytes := 0
+		var state State
+		iter := app.Coll.States.Find(userStatesSelector(objectUID)).Select(bson.M{"uid": 1, "namespace": 1, "data": 1, "encrypted": 1}).Iter()
+		for iter.Next(&state) {
+			err := app.openState(&state, nil)
+			if err != nil {
+				iter.Close()
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+				return
+			}
+			size, err := stateSize(state.Data)
+			if err != nil {
+				iter.Close()
+				app.HandleError(err, ctx, iris.StatusInternalServerError)
+				return
+			}
+			stateBytes += size
+			state = State{}
+		}
+		err = iter.Close()
+		if err != nil {
+			app.HandleError(err, ctx, iris.StatusInternalServerError)
+			return
+		}
+
+		ctx.JSON(iris.Map{
+			"usage": iris.Map{
+				"file_bytes":  usage.FileBytes,
+				"file_count":  usage.FileCount,
+				"state_bytes": stateBytes,
+			},
+			"limits": quota.limits(),
+		})
+	}
+}
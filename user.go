@@ -14,6 +14,7 @@ import (
 //    `Password` encrypted password
 //    `RecoveryCode` optional recovery code for password reset
 //    `LastLoginAt` time at which last login happened
+//    `Quota` optional overrides of `Quota` setting
 //
 type User struct {
 	ID           bson.ObjectId `bson:"_id" json:"id"`
@@ -21,6 +22,7 @@ type User struct {
 	Password     string        `bson:"password"`
 	RecoveryCode string        `bson:"recovery_code"`
 	LastLoginAt  time.Time     `bson:"last_login_at"`
+	Quota        *Quota        `bson:"quota,omitempty" json:"-"`
 }
 
 var codeLetters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
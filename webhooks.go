@@ -0,0 +1,370 @@
+package basicserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+const (
+	defaultWebhookMaxAttempts    = 8
+	defaultWebhookRetryInterval  = 10 * time.Second
+	defaultWebhookDeliveryMaxAge = 7 * 24 * time.Hour
+	maxConcurrentWebhooks        = 16
+	webhookTimeout               = 10 * time.Second
+	webhookBackoffBase           = 30 * time.Second
+	webhookBackoffMax            = 6 * time.Hour
+	webhookRetryBatchSize        = 100
+	maxWebhookResponseLength     = 1024
+	deliveryPending              = "pending"
+)
+
+var errNoSuchDelivery = errors.New("No Such Delivery")
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// Webhook is an endpoint which receives user events:
+//
+//    `URL` endpoint to which the events are `POST`ed as `application/json`
+//    `Secret` key of HMAC-SHA256 signature of the payloads
+//    `Events` types of the delivered events, all of them if empty: "register", "signin",
+//      "account_delete", "data", "file_upload" and "file_delete"
+//
+// Payloads are signed along with the timestamp sent in `X-Webhook-Timestamp` header, as
+// hex encoded HMAC-SHA256 of `{timestamp}.{body}` in `X-Webhook-Signature` header:
+//
+//		X-Webhook-Event: data
+//		X-Webhook-Delivery: 5b1fb2d4e9c1a70d0f3b8f41
+//		X-Webhook-Timestamp: 1528810196
+//		X-Webhook-Signature: sha256=0e7f...
+//
+type Webhook struct {
+	URL    string
+	Secret string
+	Events []string
+}
+
+func (webhook *Webhook) accepts(eventType string) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, event := range webhook.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEvent is a payload of the webhook delivery. Fields other than `ID`, `Type`, `UID`
+// and `CreatedAt` are set depending on the event type, the same as in the event streams.
+type WebhookEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	UID       string    `json:"uid"`
+	Email     string    `json:"email,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Keys      []string  `json:"keys,omitempty"`
+	All       bool      `json:"all,omitempty"`
+	Version   int64     `json:"version,omitempty"`
+	File      string    `json:"file,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is an entry of the webhook delivery log, which also serves as the queue
+// of the deliveries to be retried:
+//
+//    `URL` webhook endpoint
+//    `Event` type of the delivered event
+//    `Payload` JSON payload of the event
+//    `Status` "pending" until delivered, then "delivered", or "failed" after the last attempt
+//    `Attempts` number of delivery attempts
+//    `NextAttemptAt` time of the next attempt of the pending delivery
+//    `LastAttemptAt` time of the last attempt
+//    `ResponseStatus` response status code of the last attempt, 0 if it wasn't received
+//    `Error` error of the last failed attempt
+//    `ReplayOf` id of the replayed delivery
+//    `ExpiresAt` time at which delivered or failed delivery is removed from the log
+//
+type WebhookDelivery struct {
+	ID             bson.ObjectId `bson:"_id" json:"id"`
+	URL            string        `bson:"url" json:"url"`
+	Event          string        `bson:"event" json:"event"`
+	UID            bson.ObjectId `bson:"uid,omitempty" json:"uid,omitempty"`
+	Payload        string        `bson:"payload" json:"payload"`
+	Status         string        `bson:"status" json:"status"`
+	Attempts       int           `bson:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time     `bson:"next_attempt_at,omitempty" json:"next_attempt_at,omitempty"`
+	LastAttemptAt  time.Time     `bson:"last_attempt_at,omitempty" json:"last_attempt_at,omitempty"`
+	ResponseStatus int           `bson:"response_status,omitempty" json:"response_status,omitempty"`
+	Error          string        `bson:"error,omitempty" json:"error,omitempty"`
+	ReplayOf       bson.ObjectId `bson:"replay_of,omitempty" json:"replay_of,omitempty"`
+	ExpiresAt      time.Time     `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	CreatedAt      time.Time     `bson:"created_at" json:"created_at"`
+}
+
+// triggerWebhooks queues delivery of the event to every webhook which accepts it and makes
+// the first attempts in background. Deliveries are stored first, so the ones interrupted
+// by a restart are retried as well. Up to `maxConcurrentWebhooks` first attempts run at
+// once, further deliveries are left for the retries.
+func (app *BasicApp) triggerWebhooks(objectUID bson.ObjectId, event WebhookEvent) {
+	if len(app.Settings.Webhooks) == 0 {
+		return
+	}
+	event.ID = bson.NewObjectId().Hex()
+	event.UID = objectUID.Hex()
+	event.CreatedAt = time.Now()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		app.Iris.Logger().Error(err)
+		return
+	}
+
+	for _, webhook := range app.Settings.Webhooks {
+		if !webhook.accepts(event.Type) {
+			continue
+		}
+		delivery := &WebhookDelivery{
+			ID:            bson.NewObjectId(),
+			URL:           webhook.URL,
+			Event:         event.Type,
+			UID:           objectUID,
+			Payload:       string(payload),
+			Status:        deliveryPending,
+			NextAttemptAt: event.CreatedAt,
+			CreatedAt:     event.CreatedAt,
+		}
+		err = app.Coll.Deliveries.Insert(delivery)
+		if err != nil {
+			app.Iris.Logger().Error(err)
+			continue
+		}
+		select {
+		case app.webhookSlots <- struct{}{}:
+			go func(id bson.ObjectId) {
+				defer func() { <-app.webhookSlots }()
+				app.attemptDelivery(id)
+			}(delivery.ID)
+		default:
+		}
+	}
+}
+
+// publishAccountEvent triggers webhooks of "register", "signin" or "account_delete" event.
+func (app *BasicApp) publishAccountEvent(objectUID bson.ObjectId, eventType string, email string) {
+	app.triggerWebhooks(objectUID, WebhookEvent{Type: eventType, Email: email})
+}
+
+// webhook returns configured webhook of the endpoint.
+func (app *BasicApp) webhook(url string) *Webhook {
+	for i := range app.Settings.Webhooks {
+		if app.Settings.Webhooks[i].URL == url {
+			return &app.Settings.Webhooks[i]
+		}
+	}
+	return nil
+}
+
+// attemptDelivery makes an attempt of the pending delivery, which is due. The delivery is
+// claimed for the time of the attempt first, so it's not attempted concurrently. Failed
+// deliveries are retried with exponential backoff, until `WebhookMaxAttempts` is reached.
+// Delivered and failed deliveries are kept in the log for `WebhookDeliveryMaxAge`.
+func (app *BasicApp) attemptDelivery(id bson.ObjectId) {
+	now := time.Now()
+	var delivery WebhookDelivery
+	_, err := app.Coll.Deliveries.Find(bson.M{
+		"_id":             id,
+		"status":          deliveryPending,
+		"next_attempt_at": bson.M{"$lte": now},
+	}).Apply(mgo.Change{
+		Update: bson.M{
+			"$set": bson.M{"next_attempt_at": now.Add(2 * webhookTimeout)},
+			"$inc": bson.M{"attempts": 1},
+		},
+		ReturnNew: true,
+	}, &delivery)
+	if err != nil {
+		if err != mgo.ErrNotFound { // otherwise it's attempted already
+			app.Iris.Logger().Error(err)
+		}
+		return
+	}
+
+	responseStatus, err := app.sendDelivery(&delivery)
+	set := bson.M{"last_attempt_at": now, "response_status": responseStatus}
+	unset := bson.M{}
+	maxAge := app.Settings.WebhookDeliveryMaxAge
+	if maxAge <= 0 {
+		maxAge = defaultWebhookDeliveryMaxAge
+	}
+	if err == nil {
+		set["status"] = "delivered"
+		set["expires_at"] = now.Add(maxAge)
+		unset["error"] = ""
+		unset["next_attempt_at"] = ""
+	} else {
+		set["error"] = err.Error()
+		maxAttempts := app.Settings.WebhookMaxAttempts
+		if maxAttempts <= 0 {
+			maxAttempts = defaultWebhookMaxAttempts
+		}
+		if delivery.Attempts >= maxAttempts {
+			set["status"] = "failed"
+			set["expires_at"] = now.Add(maxAge)
+			unset["next_attempt_at"] = ""
+		} else {
+			set["next_attempt_at"] = now.Add(webhookBackoff(delivery.Attempts))
+		}
+	}
+	change := bson.M{"$set": set}
+	if len(unset) > 0 {
+		change["$unset"] = unset
+	}
+	err = app.Coll.Deliveries.UpdateId(delivery.ID, change)
+	if err != nil {
+		app.Iris.Logger().Error(err)
+	}
+}
+
+// sendDelivery posts signed payload to the webhook. It returns response status code, and
+// error unless the status is 2xx.
+func (app *BasicApp) sendDelivery(delivery *WebhookDelivery) (int, error) {
+	webhook := app.webhook(delivery.URL)
+	if webhook == nil {
+		return 0, errors.New("Webhook Not Configured")
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request, err := http.NewRequest("POST", webhook.URL, bytes.NewReader([]byte(delivery.Payload)))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Webhook-Event", delivery.Event)
+	request.Header.Set("X-Webhook-Delivery", delivery.ID.Hex())
+	request.Header.Set("X-Webhook-Timestamp", timestamp)
+	request.Header.Set("X-Webhook-Signature", "sha256="+signWebhookPayload(webhook.Secret, timestamp, delivery.Payload))
+
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, maxWebhookResponseLength))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, errors.New(response.Status + " " + string(body))
+	}
+	return response.StatusCode, nil
+}
+
+// signWebhookPayload returns hex encoded HMAC-SHA256 signature of the timestamped payload.
+func signWebhookPayload(secret string, timestamp string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns delay after given number of failed attempts, which doubles with
+// every attempt.
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookBackoffBase
+	for i := 1; i < attempts && delay < webhookBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > webhookBackoffMax {
+		delay = webhookBackoffMax
+	}
+	return delay
+}
+
+// DeliverWebhooks attempts the pending webhook deliveries which are due. It returns number
+// of the attempted deliveries.
+func (app *BasicApp) DeliverWebhooks() (int, error) {
+	var deliveries []WebhookDelivery
+	err := app.Coll.Deliveries.Find(bson.M{
+		"status":          deliveryPending,
+		"next_attempt_at": bson.M{"$lte": time.Now()},
+	}).Sort("next_attempt_at").Limit(webhookRetryBatchSize).Select(bson.M{"_id": 1}).All(&deliveries)
+	if err != nil {
+		return 0, err
+	}
+	for _, delivery := range deliveries {
+		app.attemptDelivery(delivery.ID)
+	}
+	return len(deliveries), nil
+}
+
+// deliverWebhooksLoop runs DeliverWebhooks every `WebhookRetryInterval`.
+func (app *BasicApp) deliverWebhooksLoop() {
+	interval := app.Settings.WebhookRetryInterval
+	if interval <= 0 {
+		interval = defaultWebhookRetryInterval
+	}
+	for range time.Tick(interval) {
+		_, err := app.DeliverWebhooks()
+		if err != nil {
+			app.Iris.Logger().Error(err)
+		}
+	}
+}
+
+// WebhookDeliveries returns log of the most recent webhook deliveries, optionally only the
+// ones with given status ("pending", "delivered" or "failed").
+func (app *BasicApp) WebhookDeliveries(status string, limit int) ([]WebhookDelivery, error) {
+	selector := bson.M{}
+	if status != "" {
+		selector["status"] = status
+	}
+	deliveries := []WebhookDelivery{}
+	err := app.Coll.Deliveries.Find(selector).Sort("-_id").Limit(limit).All(&deliveries)
+	return deliveries, err
+}
+
+// ReplayWebhookDelivery delivers the payload of the logged delivery once again, as a new
+// delivery, which is retried the same as any other. It returns the new delivery after
+// the first attempt.
+func (app *BasicApp) ReplayWebhookDelivery(id string) (*WebhookDelivery, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, errNoSuchDelivery
+	}
+	var delivery WebhookDelivery
+	err := app.Coll.Deliveries.FindId(bson.ObjectIdHex(id)).One(&delivery)
+	if err == mgo.ErrNotFound {
+		return nil, errNoSuchDelivery
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	replay := &WebhookDelivery{
+		ID:            bson.NewObjectId(),
+		URL:           delivery.URL,
+		Event:         delivery.Event,
+		UID:           delivery.UID,
+		Payload:       delivery.Payload,
+		Status:        deliveryPending,
+		NextAttemptAt: now,
+		ReplayOf:      delivery.ID,
+		CreatedAt:     now,
+	}
+	err = app.Coll.Deliveries.Insert(replay)
+	if err != nil {
+		return nil, err
+	}
+	app.attemptDelivery(replay.ID)
+
+	err = app.Coll.Deliveries.FindId(replay.ID).One(replay)
+	return replay, err
+}